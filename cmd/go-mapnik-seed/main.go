@@ -0,0 +1,112 @@
+// go-mapnik-seed renders XYZ tiles of a Mapnik stylesheet into an MBTiles file.
+//
+// Usage:
+//
+//	go-mapnik-seed -bbox 5.8,47.2,15.1,55.1 -zoom 0-10 -metatile 4 style.xml out.mbtiles
+//
+// Seeding can be interrupted (Ctrl-C) and resumed by calling go-mapnik-seed
+// with the same arguments. Existing tiles are not rendered again.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"github.com/omniscale/go-mapnik/v3/cmd/internal/cmdutil"
+	"github.com/omniscale/go-mapnik/v3/seed"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func main() {
+	bbox := flag.String("bbox", "-180,-85.0511,180,85.0511", "bbox in EPSG:4326 (minlon,minlat,maxlon,maxlat)")
+	zoom := flag.String("zoom", "0-5", "zoom level or range (e.g. 8 or 0-12)")
+	format := flag.String("format", "png", "tile format (png, jpg, webp)")
+	tileSize := flag.Int("tile-size", 256, "tile size in pixel")
+	metaTile := flag.Int("metatile", 1, "render NxN tiles at once")
+	bufferSize := flag.Int("buffer-size", 0, "buffer size in pixel")
+	workers := flag.Int("workers", 0, "number of concurrent renderers (default number of CPUs)")
+	name := flag.String("name", "", "name for the MBTiles metadata (default stylesheet)")
+	description := flag.String("description", "", "description for the MBTiles metadata")
+	attribution := flag.String("attribution", "", "attribution for the MBTiles metadata")
+	quiet := flag.Bool("quiet", false, "do not report progress")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] stylesheet.xml output.mbtiles\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := seed.Options{
+		Stylesheet:   flag.Arg(0),
+		Format:       *format,
+		TileSize:     *tileSize,
+		MetaTileSize: *metaTile,
+		BufferSize:   *bufferSize,
+		Workers:      *workers,
+		Name:         *name,
+		Description:  *description,
+		Attribution:  *attribution,
+	}
+	var err error
+	if opts.BBox, err = cmdutil.ParseBBox(*bbox); err != nil {
+		log.Fatal(err)
+	}
+	if opts.MinZoom, opts.MaxZoom, err = parseZoom(*zoom); err != nil {
+		log.Fatal(err)
+	}
+	if !*quiet {
+		opts.Progress = func(done, total int) {
+			fmt.Fprintf(os.Stderr, "\r%d/%d (%.1f%%)", done, total, float64(done)/float64(total)*100)
+			if done == total {
+				fmt.Fprintln(os.Stderr)
+			}
+		}
+	}
+
+	mb, err := seed.OpenMBTiles(flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = seed.Seed(ctx, mb, opts)
+	if cerr := mb.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "\ninterrupted, call again with the same arguments to resume")
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func parseZoom(s string) (int, int, error) {
+	from, to, found := strings.Cut(s, "-")
+	min, err := strconv.Atoi(from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid zoom %q", s)
+	}
+	if !found {
+		return min, min, nil
+	}
+	max, err := strconv.Atoi(to)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid zoom %q", s)
+	}
+	return min, max, nil
+}
@@ -0,0 +1,25 @@
+// Package cmdutil contains helpers for the command line tools.
+package cmdutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseBBox parses a bbox in the form minx,miny,maxx,maxy.
+func ParseBBox(s string) ([4]float64, error) {
+	var bbox [4]float64
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return bbox, fmt.Errorf("invalid bbox %q", s)
+	}
+	for i, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bbox, fmt.Errorf("invalid bbox %q", s)
+		}
+		bbox[i] = v
+	}
+	return bbox, nil
+}
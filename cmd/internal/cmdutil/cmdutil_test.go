@@ -0,0 +1,18 @@
+package cmdutil
+
+import "testing"
+
+func TestParseBBox(t *testing.T) {
+	bbox, err := ParseBBox("5.8, 47.2,15.1,55.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bbox != [4]float64{5.8, 47.2, 15.1, 55.1} {
+		t.Error("unexpected bbox", bbox)
+	}
+	for _, s := range []string{"", "1,2,3", "1,2,3,4,5", "1,2,3,x"} {
+		if _, err := ParseBBox(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
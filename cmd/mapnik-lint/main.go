@@ -0,0 +1,58 @@
+// mapnik-lint checks Mapnik stylesheets for errors and common problems.
+//
+// Usage:
+//
+//	mapnik-lint [-fonts dir] [-warnings=false] style.xml [other.xml ...]
+//
+// mapnik-lint prints all issues with file and line and exits with 1 if a
+// stylesheet has errors (or warnings with -fail-on-warnings).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	mapnik "github.com/omniscale/go-mapnik/v3"
+)
+
+func main() {
+	fonts := flag.String("fonts", "", "register additional fonts from this directory")
+	warnings := flag.Bool("warnings", true, "print warnings")
+	failOnWarnings := flag.Bool("fail-on-warnings", false, "exit with 1 for warnings")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] stylesheet.xml [...]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *fonts != "" {
+		if err := mapnik.RegisterFonts(*fonts); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	failed := false
+	for _, stylesheet := range flag.Args() {
+		for _, issue := range mapnik.Validate(stylesheet) {
+			if issue.Severity == mapnik.SeverityWarning {
+				if *failOnWarnings {
+					failed = true
+				}
+				if !*warnings {
+					continue
+				}
+			} else {
+				failed = true
+			}
+			fmt.Println(issue)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
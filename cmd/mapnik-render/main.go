@@ -0,0 +1,211 @@
+// mapnik-render renders a Mapnik stylesheet into an image file.
+//
+// Usage:
+//
+//	mapnik-render -bbox 5.8,47.2,15.1,55.1 -size 1000x800 -format png32 style.xml out.png
+//
+// The map is zoomed to the maximum extent if no -bbox is given. Use - as
+// output to write the image to stdout. -list-layers prints all layers of
+// the stylesheet instead of rendering it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image/color"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	mapnik "github.com/omniscale/go-mapnik/v3"
+	"github.com/omniscale/go-mapnik/v3/cmd/internal/cmdutil"
+)
+
+var (
+	bbox          = flag.String("bbox", "", "bbox in map SRS (minx,miny,maxx,maxy), defaults to the maximum extent")
+	srs           = flag.String("srs", "", "map SRS (e.g. epsg:3857), defaults to the SRS of the stylesheet")
+	size          = flag.String("size", "800x600", "image size in pixel (WIDTHxHEIGHT)")
+	scale         = flag.Float64("scale", 0, "render at a fixed scale denominator")
+	scaleFactor   = flag.Float64("scale-factor", 1, "scale factor for fonts, line widths, etc.")
+	format        = flag.String("format", "png256", "image format (png256, png32, jpeg85, webp, etc.)")
+	layers        = flag.String("layers", "", "render only these layers (comma separated)")
+	excludeLayers = flag.String("exclude-layers", "", "do not render these layers (comma separated)")
+	background    = flag.String("background", "", "background color (#rrggbb, #rrggbbaa or transparent)")
+	maxExtent     = flag.String("max-extent", "", "maximum extent in map SRS (minx,miny,maxx,maxy)")
+	bufferSize    = flag.Int("buffer-size", -1, "buffer size in pixel")
+	listLayers    = flag.Bool("list-layers", false, "list all layers of the stylesheet and exit")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [options] stylesheet.xml output\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "       %s -list-layers stylesheet.xml\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if (*listLayers && flag.NArg() < 1) || (!*listLayers && flag.NArg() != 2) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var err error
+	if *listLayers {
+		err = list(flag.Arg(0))
+	} else {
+		err = run(flag.Arg(0), flag.Arg(1))
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// list prints name, status and SRS of all layers of the stylesheet.
+func list(stylesheet string) error {
+	m := mapnik.New()
+	defer m.Free()
+	if err := m.Load(stylesheet); err != nil {
+		return err
+	}
+	for _, l := range m.Layers() {
+		status := "on"
+		if !l.Active {
+			status = "off"
+		}
+		fmt.Printf("%s\t%s\t%s\n", l.Name, status, l.SRS)
+	}
+	return nil
+}
+
+// run renders the stylesheet to output, or to stdout if output is -.
+func run(stylesheet, output string) error {
+	width, height, err := parseSize(*size)
+	if err != nil {
+		return err
+	}
+	m := mapnik.NewSized(width, height)
+	defer m.Free()
+	if err := m.Load(stylesheet); err != nil {
+		return err
+	}
+	if *srs != "" {
+		m.SetSRS(*srs)
+	}
+	if *maxExtent != "" {
+		e, err := cmdutil.ParseBBox(*maxExtent)
+		if err != nil {
+			return err
+		}
+		m.SetMaxExtent(e[0], e[1], e[2], e[3])
+	}
+	if *bufferSize >= 0 {
+		m.SetBufferSize(*bufferSize)
+	}
+	if *background != "" {
+		c, err := parseColor(*background)
+		if err != nil {
+			return err
+		}
+		m.SetBackgroundColor(c)
+	}
+	if *layers != "" || *excludeLayers != "" {
+		if err := selectLayers(m, *layers, *excludeLayers); err != nil {
+			return err
+		}
+	}
+	if *bbox != "" {
+		b, err := cmdutil.ParseBBox(*bbox)
+		if err != nil {
+			return err
+		}
+		m.ZoomTo(b[0], b[1], b[2], b[3])
+	} else if err := m.ZoomAll(); err != nil {
+		return err
+	}
+
+	opts := mapnik.RenderOpts{Scale: *scale, ScaleFactor: *scaleFactor, Format: *format}
+	if output == "-" {
+		b, err := m.Render(opts)
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return m.RenderToFile(opts, output)
+}
+
+func selectLayers(m *mapnik.Map, include, exclude string) error {
+	included := map[string]bool{}
+	excluded := map[string]bool{}
+	known := map[string]bool{}
+	for _, l := range m.Layers() {
+		known[l.Name] = true
+	}
+	for _, l := range splitList(include) {
+		if !known[l] {
+			return fmt.Errorf("unknown layer %q", l)
+		}
+		included[l] = true
+	}
+	for _, l := range splitList(exclude) {
+		if !known[l] {
+			return fmt.Errorf("unknown layer %q", l)
+		}
+		excluded[l] = true
+	}
+	m.SelectLayers(mapnik.SelectorFunc(func(name string) mapnik.Status {
+		if excluded[name] {
+			return mapnik.Exclude
+		}
+		if included[name] {
+			return mapnik.Include
+		}
+		if len(included) > 0 {
+			return mapnik.Exclude
+		}
+		return mapnik.Default
+	}))
+	return nil
+}
+
+func splitList(s string) []string {
+	var result []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func parseSize(s string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	width, err := strconv.Atoi(w)
+	if !ok || err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid size %q", s)
+	}
+	return width, height, nil
+}
+
+func parseColor(s string) (color.NRGBA, error) {
+	if s == "transparent" {
+		return color.NRGBA{}, nil
+	}
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+	if len(hex) != 8 {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+	return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, nil
+}
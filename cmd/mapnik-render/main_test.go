@@ -0,0 +1,90 @@
+package main
+
+import (
+	"image/color"
+	"reflect"
+	"testing"
+
+	mapnik "github.com/omniscale/go-mapnik/v3"
+)
+
+func TestSelectLayers(t *testing.T) {
+	for _, tc := range []struct {
+		include, exclude string
+		active           []string
+	}{
+		{"", "", []string{"layerA", "layerB", "layerC"}},
+		{"layerA, layerD", "", []string{"layerA", "layerD"}},
+		{"", "layerB", []string{"layerA", "layerC"}},
+		{"layerA,layerB", "layerB", []string{"layerA"}},
+	} {
+		m := mapnik.New()
+		if err := m.Load("../../test/map.xml"); err != nil {
+			t.Fatal(err)
+		}
+		if err := selectLayers(m, tc.include, tc.exclude); err != nil {
+			t.Fatal(err)
+		}
+		var active []string
+		for _, l := range m.Layers() {
+			if l.Active {
+				active = append(active, l.Name)
+			}
+		}
+		if !reflect.DeepEqual(tc.active, active) {
+			t.Errorf("unexpected active layers for %q/%q: %v", tc.include, tc.exclude, active)
+		}
+		m.Free()
+	}
+
+	m := mapnik.New()
+	defer m.Free()
+	if err := m.Load("../../test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := selectLayers(m, "unknown", ""); err == nil {
+		t.Error("expected error for unknown layer")
+	}
+	if err := selectLayers(m, "", "unknown"); err == nil {
+		t.Error("expected error for unknown excluded layer")
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	w, h, err := parseSize("1000X800")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != 1000 || h != 800 {
+		t.Error("unexpected size", w, h)
+	}
+	for _, s := range []string{"", "100", "100x", "x100", "0x100", "100x-1", "axb"} {
+		if _, _, err := parseSize(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for _, tc := range []struct {
+		s     string
+		color color.NRGBA
+	}{
+		{"transparent", color.NRGBA{}},
+		{"#ff8000", color.NRGBA{255, 128, 0, 255}},
+		{"ff800080", color.NRGBA{255, 128, 0, 128}},
+	} {
+		c, err := parseColor(tc.s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != tc.color {
+			t.Errorf("unexpected color for %q: %v", tc.s, c)
+		}
+	}
+	for _, s := range []string{"", "red", "#fff", "#gg0000"} {
+		if _, err := parseColor(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"runtime"
+	"unsafe"
+)
+
+// ColorizerMode defines how values between two stops are colored.
+type ColorizerMode int
+
+var (
+	// ColorizerInherit uses the default mode of the RasterColorizer.
+	ColorizerInherit = ColorizerMode(C.MAPNIK_COLORIZER_INHERIT)
+	// ColorizerLinear interpolates the colors of the stop and the next stop.
+	ColorizerLinear = ColorizerMode(C.MAPNIK_COLORIZER_LINEAR)
+	// ColorizerDiscrete uses the color of the stop up to the next stop.
+	ColorizerDiscrete = ColorizerMode(C.MAPNIK_COLORIZER_DISCRETE)
+	// ColorizerExact only colors values that are equal to the stop value
+	// (within Epsilon).
+	ColorizerExact = ColorizerMode(C.MAPNIK_COLORIZER_EXACT)
+)
+
+func (m ColorizerMode) String() string {
+	switch m {
+	case ColorizerInherit:
+		return "inherit"
+	case ColorizerLinear:
+		return "linear"
+	case ColorizerDiscrete:
+		return "discrete"
+	case ColorizerExact:
+		return "exact"
+	}
+	return "unknown"
+}
+
+func (m ColorizerMode) valid() bool {
+	switch m {
+	case ColorizerInherit, ColorizerLinear, ColorizerDiscrete, ColorizerExact:
+		return true
+	}
+	return false
+}
+
+// ColorizerStop is a single stop of a RasterColorizer.
+type ColorizerStop struct {
+	Value float64
+	Color color.NRGBA
+	// Mode for values from this stop to the next stop.
+	Mode  ColorizerMode
+	Label string
+}
+
+// RasterColorizer colors single band rasters (e.g. DEMs) of raster
+// symbolizers, like the RasterColorizer element of Mapnik XML stylesheets.
+// See https://github.com/mapnik/mapnik/wiki/RasterColorizer
+type RasterColorizer struct {
+	// DefaultMode for all stops with ColorizerInherit. Defaults to
+	// ColorizerLinear.
+	DefaultMode ColorizerMode
+	// DefaultColor for values below the first stop. Defaults to
+	// transparent.
+	DefaultColor color.NRGBA
+	// Epsilon for comparing values of ColorizerExact stops. Uses Mapnik's
+	// default if zero.
+	Epsilon float64
+	// Stops in strictly ascending order of their values.
+	Stops []ColorizerStop
+}
+
+// ColorRampStop is a value and its color for ColorRamp.
+type ColorRampStop struct {
+	Value float64
+	Color color.NRGBA
+}
+
+// ColorRamp returns a RasterColorizer with a stop for each of the stops.
+// The stops need to be in strictly ascending order of their values.
+func ColorRamp(mode ColorizerMode, stops []ColorRampStop) *RasterColorizer {
+	c := &RasterColorizer{DefaultMode: mode, Stops: make([]ColorizerStop, len(stops))}
+	for i, s := range stops {
+		c.Stops[i] = ColorizerStop{Value: s.Value, Color: s.Color}
+	}
+	return c
+}
+
+// SetRasterColorizer sets the colorizer of all RasterSymbolizers of the
+// style. Returns an error if c is nil, if a mode is unknown, if the stops
+// are not in strictly ascending order, or if the style does not exist or
+// contains no RasterSymbolizer.
+func (m *Map) SetRasterColorizer(style string, c *RasterColorizer) error {
+	if m.m == nil {
+		return ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	if c == nil {
+		return errors.New("mapnik: missing raster colorizer")
+	}
+	if !c.DefaultMode.valid() {
+		return fmt.Errorf("mapnik: invalid colorizer mode %d", c.DefaultMode)
+	}
+	for _, s := range c.Stops {
+		if !s.Mode.valid() {
+			return fmt.Errorf("mapnik: invalid colorizer mode %d of stop %g", s.Mode, s.Value)
+		}
+	}
+	mode := c.DefaultMode
+	if mode == ColorizerInherit {
+		mode = ColorizerLinear
+	}
+	dc := c.DefaultColor
+	rc := C.mapnik_raster_colorizer(C.int(mode), C.uint8_t(dc.R), C.uint8_t(dc.G), C.uint8_t(dc.B), C.uint8_t(dc.A), C.double(c.Epsilon))
+	defer C.mapnik_raster_colorizer_free(rc)
+	for _, s := range c.Stops {
+		label := C.CString(s.Label)
+		added := C.mapnik_raster_colorizer_add_stop(rc, C.double(s.Value), C.int(s.Mode),
+			C.uint8_t(s.Color.R), C.uint8_t(s.Color.G), C.uint8_t(s.Color.B), C.uint8_t(s.Color.A), label)
+		C.free(unsafe.Pointer(label))
+		if added == 0 {
+			return fmt.Errorf("mapnik: colorizer stop %g is not above the previous stop", s.Value)
+		}
+	}
+
+	cs := C.CString(style)
+	defer C.free(unsafe.Pointer(cs))
+	switch n := C.mapnik_map_set_raster_colorizer(m.m, cs, rc); {
+	case n < 0:
+		return fmt.Errorf("mapnik: style %q not found", style)
+	case n == 0:
+		return fmt.Errorf("mapnik: style %q contains no RasterSymbolizer", style)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package mapnik
+
+import (
+	"encoding/binary"
+	"image/color"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColorRamp(t *testing.T) {
+	c := ColorRamp(ColorizerDiscrete, []ColorRampStop{
+		{-10, color.NRGBA{0, 0, 255, 255}},
+		{200, color.NRGBA{0, 255, 0, 255}},
+		{1000, color.NRGBA{255, 255, 255, 255}},
+	})
+	assertEqual(t, &RasterColorizer{
+		DefaultMode: ColorizerDiscrete,
+		Stops: []ColorizerStop{
+			{Value: -10, Color: color.NRGBA{0, 0, 255, 255}},
+			{Value: 200, Color: color.NRGBA{0, 255, 0, 255}},
+			{Value: 1000, Color: color.NRGBA{255, 255, 255, 255}},
+		},
+	}, c)
+	assertEqual(t, "discrete", c.DefaultMode.String())
+}
+
+func TestSetRasterColorizer(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "style.xml")
+	if err := os.WriteFile(fname, []byte(`<Map srs="epsg:4326">
+    <Style name="dem">
+        <Rule>
+            <RasterSymbolizer />
+        </Rule>
+        <Rule>
+            <RasterSymbolizer opacity="0.5" />
+        </Rule>
+    </Style>
+    <Style name="polygon">
+        <Rule>
+            <PolygonSymbolizer />
+        </Rule>
+    </Style>
+</Map>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	defer m.Free()
+	if err := m.Load(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	c := ColorRamp(ColorizerLinear, []ColorRampStop{
+		{0, color.NRGBA{0, 128, 0, 255}},
+		{1000, color.NRGBA{128, 64, 0, 255}},
+	})
+	c.Stops = append(c.Stops, ColorizerStop{Value: 9999, Color: color.NRGBA{}, Mode: ColorizerExact, Label: "nodata"})
+	if err := m.SetRasterColorizer("dem", c); err != nil {
+		t.Fatal(err)
+	}
+	for _, stops := range [][]ColorRampStop{
+		{{1000, color.NRGBA{}}, {0, color.NRGBA{}}},
+		{{0, color.NRGBA{}}, {0, color.NRGBA{}}},
+	} {
+		if err := m.SetRasterColorizer("dem", ColorRamp(ColorizerLinear, stops)); err == nil {
+			t.Error("expected error for stops", stops)
+		}
+	}
+	if err := m.SetRasterColorizer("dem", nil); err == nil {
+		t.Error("expected error for nil colorizer")
+	}
+	if err := m.SetRasterColorizer("dem", ColorRamp(ColorizerMode(42), nil)); err == nil {
+		t.Error("expected error for unknown default mode")
+	}
+	invalid := ColorRamp(ColorizerLinear, []ColorRampStop{{0, color.NRGBA{}}})
+	invalid.Stops[0].Mode = ColorizerMode(-1)
+	if err := m.SetRasterColorizer("dem", invalid); err == nil {
+		t.Error("expected error for unknown stop mode")
+	}
+	if err := m.SetRasterColorizer("polygon", c); err == nil {
+		t.Error("expected error for style without RasterSymbolizer")
+	}
+	if err := m.SetRasterColorizer("missing", c); err == nil {
+		t.Error("expected error for missing style")
+	}
+}
+
+// writeGrayTIFF writes a georeferenced GeoTIFF with a single 8-bit band.
+func writeGrayTIFF(t *testing.T, fname string, width, height int, pix []byte, g georeference) {
+	t.Helper()
+	order := binary.LittleEndian
+	tags := [][2]uint32{
+		{256, uint32(width)},  // ImageWidth
+		{257, uint32(height)}, // ImageLength
+		{258, 8},              // BitsPerSample
+		{259, 1},              // Compression: none
+		{262, 1},              // PhotometricInterpretation: BlackIsZero
+		{273, 0},              // StripOffsets, set below
+		{277, 1},              // SamplesPerPixel
+		{278, uint32(height)}, // RowsPerStrip
+		{279, uint32(len(pix))},
+	}
+	ifdSize := 2 + len(tags)*12 + 4
+	tags[5][1] = uint32(8 + ifdSize)
+	b := make([]byte, 8+ifdSize)
+	copy(b, "II")
+	order.PutUint16(b[2:], 42)
+	order.PutUint32(b[4:], 8)
+	order.PutUint16(b[8:], uint16(len(tags)))
+	for i, tag := range tags {
+		e := b[10+i*12:]
+		order.PutUint16(e, uint16(tag[0]))
+		order.PutUint16(e[2:], 4) // LONG
+		order.PutUint32(e[4:], 1)
+		order.PutUint32(e[8:], tag[1])
+	}
+	b = append(b, pix...)
+	b, err := geoTIFF(b, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(fname, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRenderRasterColorizer(t *testing.T) {
+	dir := t.TempDir()
+	// 2x1 pixel raster with the values 10 and 200
+	writeGrayTIFF(t, filepath.Join(dir, "dem.tif"), 2, 1, []byte{10, 200}, georeference{
+		extent: [4]float64{0, 0, 2, 1}, width: 2, height: 1, srs: "epsg:4326", geographic: true,
+	})
+	fname := filepath.Join(dir, "style.xml")
+	if err := os.WriteFile(fname, []byte(`<Map srs="epsg:4326">
+    <Style name="dem">
+        <Rule>
+            <RasterSymbolizer />
+        </Rule>
+    </Style>
+    <Layer name="dem" srs="epsg:4326">
+        <StyleName>dem</StyleName>
+        <Datasource>
+            <Parameter name="type">gdal</Parameter>
+            <Parameter name="file">dem.tif</Parameter>
+        </Datasource>
+    </Layer>
+</Map>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := NewSized(200, 100)
+	defer m.Free()
+	if err := m.Load(fname); err != nil {
+		if strings.Contains(err.Error(), "gdal") {
+			t.Skip("gdal plugin not available:", err)
+		}
+		t.Fatal(err)
+	}
+	m.ZoomTo(0, 0, 2, 1)
+
+	red := color.NRGBA{255, 0, 0, 255}
+	blue := color.NRGBA{0, 0, 255, 255}
+	if err := m.SetRasterColorizer("dem", ColorRamp(ColorizerDiscrete, []ColorRampStop{
+		{0, red},
+		{100, blue},
+	})); err != nil {
+		t.Fatal(err)
+	}
+	img, err := m.RenderImage(RenderOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, red, img.At(50, 50))
+	assertEqual(t, blue, img.At(150, 50))
+}
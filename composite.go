@@ -0,0 +1,87 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// CompositeLayer is a single map of Composite.
+type CompositeLayer struct {
+	Map *Map
+	// Opts for rendering the map. Format, Encoding and Georeference are
+	// ignored.
+	Opts RenderOpts
+	// CompOp is the composition operation of the map with the maps below
+	// ("src-over", "multiply", "screen", etc. see
+	// https://github.com/mapnik/mapnik/wiki/Compositing). Defaults to
+	// "src-over".
+	CompOp string
+	// Opacity of the map from 0 to 1. Defaults to 1 if zero.
+	Opacity float64
+}
+
+// Composite renders all maps and composes them into a single image. The
+// first map is at the bottom. All maps need the same size. Maps can use the
+// same LabelDetector with their RenderOpts to avoid overlapping labels.
+func Composite(layers []CompositeLayer) (*Image, error) {
+	if len(layers) == 0 {
+		return nil, errors.New("mapnik: no layers to composite")
+	}
+	width, height := layers[0].Map.width, layers[0].Map.height
+	for _, l := range layers {
+		if l.Map.m == nil {
+			return nil, ErrMapFreed
+		}
+		if l.Map.width != width || l.Map.height != height {
+			return nil, fmt.Errorf("mapnik: map size %dx%d differs from %dx%d", l.Map.width, l.Map.height, width, height)
+		}
+		if l.CompOp != "" {
+			cs := C.CString(l.CompOp)
+			valid := C.mapnik_comp_op_is_valid(cs) == 1
+			C.free(unsafe.Pointer(cs))
+			if !valid {
+				return nil, fmt.Errorf("mapnik: invalid comp-op %q", l.CompOp)
+			}
+		}
+	}
+
+	img := newImage(C.mapnik_image_blank(C.int(width), C.int(height)))
+	for _, l := range layers {
+		if err := img.composite(l); err != nil {
+			img.Free()
+			return nil, err
+		}
+	}
+	C.mapnik_image_demultiply(img.i)
+	return img, nil
+}
+
+func (img *Image) composite(l CompositeLayer) error {
+	defer runtime.KeepAlive(l.Map)
+	i, err := l.Map.renderImage(l.Opts, "composite")
+	if err != nil {
+		return err
+	}
+	defer C.mapnik_image_free(i)
+
+	compOp := l.CompOp
+	if compOp == "" {
+		compOp = "src-over"
+	}
+	opacity := l.Opacity
+	if opacity == 0.0 {
+		opacity = 1.0
+	}
+	cs := C.CString(compOp)
+	defer C.free(unsafe.Pointer(cs))
+	if C.mapnik_image_composite(img.i, i, cs, C.double(opacity)) != 0 {
+		return errors.New("mapnik: " + C.GoString(C.mapnik_image_last_error(img.i)))
+	}
+	return nil
+}
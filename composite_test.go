@@ -0,0 +1,82 @@
+package mapnik
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestComposite(t *testing.T) {
+	base := New()
+	defer base.Free()
+	if err := base.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	base.ZoomAll()
+
+	overlay := base.Clone()
+	defer overlay.Free()
+	overlay.SetBackgroundColor(color.NRGBA{255, 255, 255, 255})
+
+	expected, err := base.RenderImage(RenderOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Composite([]CompositeLayer{{Map: base}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Free()
+	assertImageEqual(t, expected, img.NRGBA())
+
+	// opaque white overlay with half opacity
+	img, err = Composite([]CompositeLayer{
+		{Map: base},
+		{Map: overlay, Opts: RenderOpts{Layers: []string{"unknown"}}, Opacity: 0.5},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Free()
+	c := img.NRGBA().NRGBAAt(0, 0)
+	bg := expected.NRGBAAt(0, 0)
+	if diff := int(c.R) - (int(bg.R)+255)/2; diff < -1 || diff > 1 {
+		t.Error("unexpected color", c, bg)
+	}
+	assertEqual(t, uint8(255), c.A)
+
+	// multiply with white keeps the base
+	img, err = Composite([]CompositeLayer{
+		{Map: base},
+		{Map: overlay, Opts: RenderOpts{Layers: []string{"unknown"}}, CompOp: "multiply"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Free()
+	assertImageEqual(t, expected, img.NRGBA())
+}
+
+func TestCompositeErrors(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	small := NewSized(100, 100)
+	defer small.Free()
+
+	if _, err := Composite(nil); err == nil {
+		t.Error("expected error for empty layers")
+	}
+	if _, err := Composite([]CompositeLayer{{Map: m}, {Map: m, CompOp: "invalid"}}); err == nil {
+		t.Error("expected error for invalid comp-op")
+	}
+	if _, err := Composite([]CompositeLayer{{Map: m}, {Map: small}}); err == nil {
+		t.Error("expected error for different sizes")
+	}
+	freed := New()
+	freed.Free()
+	if _, err := Composite([]CompositeLayer{{Map: freed}}); err != ErrMapFreed {
+		t.Error("expected ErrMapFreed, got", err)
+	}
+}
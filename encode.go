@@ -0,0 +1,382 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// EncodeOptions are typed options for one of the image formats supported
+// by Mapnik. See PNGOptions, JPEGOptions, WebPOptions and TIFFOptions.
+type EncodeOptions interface {
+	// Format returns the Mapnik format string (e.g. 'png8:m=h:z=9').
+	Format() string
+	// Validate returns an error if the options contain invalid values.
+	Validate() error
+}
+
+// Quantizer is the color quantization method for paletted PNGs.
+type Quantizer string
+
+const (
+	// Octree quantizer, fast but lower quality for images with gradients.
+	Octree Quantizer = "o"
+	// Hextree quantizer, supports Gamma and alpha gradients.
+	Hextree Quantizer = "h"
+)
+
+// Transparency defines how alpha values are stored in PNGs.
+type Transparency int
+
+const (
+	// TransparencyDefault uses Mapnik's default (TransparencyFull).
+	TransparencyDefault Transparency = iota
+	// TransparencyNone drops the alpha channel.
+	TransparencyNone
+	// TransparencyBinary stores pixels as either opaque or fully transparent.
+	TransparencyBinary
+	// TransparencyFull keeps all alpha values.
+	TransparencyFull
+)
+
+// PNGOptions for PNG encoding.
+type PNGOptions struct {
+	// Paletted enables 8-bit paletted output (png8) instead of 32-bit RGBA.
+	Paletted bool
+	// Colors is the maximum number of palette colors (0 for the default, or
+	// 1-256). Requires Paletted.
+	Colors int
+	// Quantizer for paletted output. Requires Paletted.
+	Quantizer Quantizer
+	// Compression is the zlib compression level (1-9). 0 uses Mapnik's
+	// default and -1 disables compression.
+	Compression int
+	// Transparency defines how alpha values are stored.
+	Transparency Transparency
+	// PaletteFile is the path to a fixed palette in RGBA (four bytes for each
+	// color), RGB (.rgb suffix, three bytes) or Adobe Color Table (.act
+	// suffix) format. Requires Paletted.
+	PaletteFile string
+	// Palette is a fixed palette. Takes precedence over PaletteFile. Requires Paletted.
+	Palette *Palette
+	// Gamma for the Hextree quantizer.
+	Gamma float64
+}
+
+func (o PNGOptions) Format() string {
+	parts := []string{"png32"}
+	if o.Paletted {
+		parts[0] = "png8"
+	}
+	if o.Colors > 0 {
+		parts = append(parts, fmt.Sprintf("c=%d", o.Colors))
+	}
+	if o.Quantizer != "" {
+		parts = append(parts, "m="+string(o.Quantizer))
+	}
+	if o.Compression > 0 {
+		parts = append(parts, fmt.Sprintf("z=%d", o.Compression))
+	} else if o.Compression == -1 {
+		parts = append(parts, "z=0")
+	}
+	if o.Transparency != TransparencyDefault {
+		parts = append(parts, fmt.Sprintf("t=%d", o.Transparency-TransparencyNone))
+	}
+	if o.Gamma > 0 {
+		parts = append(parts, fmt.Sprintf("g=%g", o.Gamma))
+	}
+	return strings.Join(parts, ":")
+}
+
+func (o PNGOptions) Validate() error {
+	if o.Colors < 0 || o.Colors > 256 {
+		return fmt.Errorf("mapnik: invalid PNG colors %d, expected 0 for the default, or 1-256", o.Colors)
+	}
+	if o.Quantizer != "" && o.Quantizer != Octree && o.Quantizer != Hextree {
+		return fmt.Errorf("mapnik: invalid PNG quantizer %q", o.Quantizer)
+	}
+	if o.Compression < -1 || o.Compression > 9 {
+		return fmt.Errorf("mapnik: invalid PNG compression %d, expected 1-9 or -1", o.Compression)
+	}
+	if o.Transparency < TransparencyDefault || o.Transparency > TransparencyFull {
+		return fmt.Errorf("mapnik: invalid PNG transparency %d", o.Transparency)
+	}
+	if o.Gamma < 0 {
+		return fmt.Errorf("mapnik: invalid PNG gamma %g", o.Gamma)
+	}
+	if !o.Paletted && (o.Colors != 0 || o.Quantizer != "" || o.PaletteFile != "" || o.Palette != nil || o.Gamma != 0) {
+		return errors.New("mapnik: PNG colors, quantizer, palette and gamma require paletted output")
+	}
+	if o.Gamma != 0 && o.Quantizer != Hextree {
+		return errors.New("mapnik: PNG gamma requires hextree quantizer")
+	}
+	if o.Palette != nil && o.Palette.freed() {
+		return ErrPaletteFreed
+	}
+	if o.Palette == nil && o.PaletteFile != "" {
+		if _, err := os.Stat(o.PaletteFile); err != nil {
+			return fmt.Errorf("mapnik: PNG palette: %w", err)
+		}
+	}
+	return nil
+}
+
+// JPEGOptions for JPEG encoding.
+type JPEGOptions struct {
+	// Quality from 1-100. 0 uses Mapnik's default (85).
+	Quality int
+}
+
+func (o JPEGOptions) Format() string {
+	if o.Quality > 0 {
+		return fmt.Sprintf("jpeg%d", o.Quality)
+	}
+	return "jpeg"
+}
+
+func (o JPEGOptions) Validate() error {
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("mapnik: invalid JPEG quality %d, expected 1-100", o.Quality)
+	}
+	return nil
+}
+
+// WebPOptions for WebP encoding.
+type WebPOptions struct {
+	// Quality from 0-100. 0 uses Mapnik's default (75).
+	Quality float64
+	// Method from 1 (fast) to 6 (slower, but smaller). 0 uses Mapnik's default (4).
+	Method int
+	// Lossless enables lossless encoding.
+	Lossless bool
+	// NoAlpha drops the alpha channel.
+	NoAlpha bool
+}
+
+func (o WebPOptions) Format() string {
+	parts := []string{"webp"}
+	if o.Quality > 0 {
+		parts = append(parts, fmt.Sprintf("quality=%g", o.Quality))
+	}
+	if o.Method > 0 {
+		parts = append(parts, fmt.Sprintf("method=%d", o.Method))
+	}
+	if o.Lossless {
+		parts = append(parts, "lossless=1")
+	}
+	if o.NoAlpha {
+		parts = append(parts, "alpha=false")
+	}
+	return strings.Join(parts, ":")
+}
+
+func (o WebPOptions) Validate() error {
+	if o.Quality < 0 || o.Quality > 100 {
+		return fmt.Errorf("mapnik: invalid WebP quality %g, expected 0-100", o.Quality)
+	}
+	if o.Method < 0 || o.Method > 6 {
+		return fmt.Errorf("mapnik: invalid WebP method %d, expected 1-6", o.Method)
+	}
+	return nil
+}
+
+// TIFFCompression is the compression method for TIFF images.
+type TIFFCompression string
+
+const (
+	TIFFDeflate      TIFFCompression = "deflate"
+	TIFFAdobeDeflate TIFFCompression = "adobedeflate"
+	TIFFLZW          TIFFCompression = "lzw"
+	TIFFNone         TIFFCompression = "none"
+)
+
+// TIFFMethod defines the internal layout of TIFF images.
+type TIFFMethod string
+
+const (
+	TIFFScanline TIFFMethod = "scanline"
+	TIFFStrip    TIFFMethod = "strip"
+	TIFFTiled    TIFFMethod = "tiled"
+)
+
+// TIFFOptions for TIFF encoding.
+type TIFFOptions struct {
+	// Compression method. Empty uses Mapnik's default (deflate).
+	Compression TIFFCompression
+	// Method for the image layout. Empty uses Mapnik's default (scanline).
+	Method TIFFMethod
+	// ZLevel is the compression level (1-9) for deflate. 0 uses Mapnik's default (4).
+	ZLevel int
+	// TileWidth and TileHeight in pixel for the TIFFTiled method.
+	TileWidth  int
+	TileHeight int
+}
+
+func (o TIFFOptions) Format() string {
+	parts := []string{"tiff"}
+	if o.Compression != "" {
+		parts = append(parts, "compression="+string(o.Compression))
+	}
+	if o.Method != "" {
+		parts = append(parts, "method="+string(o.Method))
+	}
+	if o.ZLevel > 0 {
+		parts = append(parts, fmt.Sprintf("zlevel=%d", o.ZLevel))
+	}
+	if o.TileWidth > 0 {
+		parts = append(parts, fmt.Sprintf("tile_width=%d", o.TileWidth))
+	}
+	if o.TileHeight > 0 {
+		parts = append(parts, fmt.Sprintf("tile_height=%d", o.TileHeight))
+	}
+	return strings.Join(parts, ":")
+}
+
+func (o TIFFOptions) Validate() error {
+	switch o.Compression {
+	case "", TIFFDeflate, TIFFAdobeDeflate, TIFFLZW, TIFFNone:
+	default:
+		return fmt.Errorf("mapnik: invalid TIFF compression %q", o.Compression)
+	}
+	switch o.Method {
+	case "", TIFFScanline, TIFFStrip, TIFFTiled:
+	default:
+		return fmt.Errorf("mapnik: invalid TIFF method %q", o.Method)
+	}
+	if o.ZLevel < 0 || o.ZLevel > 9 {
+		return fmt.Errorf("mapnik: invalid TIFF zlevel %d, expected 1-9", o.ZLevel)
+	}
+	if o.TileWidth < 0 || o.TileHeight < 0 {
+		return errors.New("mapnik: invalid TIFF tile size")
+	}
+	if (o.TileWidth > 0 || o.TileHeight > 0) && o.Method != TIFFTiled {
+		return errors.New("mapnik: TIFF tile size requires tiled method")
+	}
+	return nil
+}
+
+// EncodeWith encodes image.Image with Mapniks image encoder, like Encode.
+func EncodeWith(img image.Image, opts EncodeOptions) ([]byte, error) {
+	enc, err := newEncoding("", opts)
+	if err != nil {
+		observeError(ErrorFormat)
+		return nil, err
+	}
+	tmp := toNRGBA(img)
+	i := C.mapnik_image_from_raw(
+		(*C.uint8_t)(unsafe.Pointer(&tmp.Pix[0])),
+		C.int(tmp.Bounds().Dx()),
+		C.int(tmp.Bounds().Dy()),
+	)
+	defer C.mapnik_image_free(i)
+	return enc.encode(i)
+}
+
+// EncodeWith returns the image encoded with the given options.
+func (i *Image) EncodeWith(opts EncodeOptions) ([]byte, error) {
+	if i.i == nil {
+		return nil, ErrImageFreed
+	}
+	defer runtime.KeepAlive(i)
+	enc, err := newEncoding("", opts)
+	if err != nil {
+		observeError(ErrorFormat)
+		return nil, err
+	}
+	return enc.encode(i.i)
+}
+
+// encoding is a validated format string, optionally with a fixed palette.
+type encoding struct {
+	format      string
+	palette     *Palette
+	paletteFile string
+}
+
+// newEncoding returns the encoding for opts, or for the format string if
+// opts is nil. Empty formats default to png256. Returns an error for
+// unknown formats.
+func newEncoding(format string, opts EncodeOptions) (encoding, error) {
+	if opts == nil {
+		if format == "" {
+			format = "png256"
+		}
+		if baseFormat(format) == "" {
+			return encoding{}, fmt.Errorf("mapnik: unknown format %q", format)
+		}
+		return encoding{format: format}, nil
+	}
+	if err := opts.Validate(); err != nil {
+		return encoding{}, err
+	}
+	enc := encoding{format: opts.Format()}
+	if png, ok := opts.(PNGOptions); ok {
+		enc.palette = png.Palette
+		if enc.palette == nil {
+			enc.paletteFile = png.PaletteFile
+		}
+	}
+	return enc, nil
+}
+
+// hasPalette returns whether the encoding requires a fixed palette.
+func (e encoding) hasPalette() bool {
+	return e.palette != nil || e.paletteFile != ""
+}
+
+// isTIFF returns whether the encoding is a TIFF format.
+func (e encoding) isTIFF() bool {
+	return strings.HasPrefix(e.format, "tiff")
+}
+
+func (e encoding) encode(i *C.struct__mapnik_image_t) ([]byte, error) {
+	start := time.Now()
+	b, err := e.encodeBlob(i)
+	if err != nil {
+		observeError(ErrorEncode)
+		return nil, err
+	}
+	observeEncode(e.format, start, len(b))
+	return b, nil
+}
+
+func (e encoding) encodeBlob(i *C.struct__mapnik_image_t) ([]byte, error) {
+	if !e.hasPalette() {
+		return encodeImage(i, e.format)
+	}
+	var p *C.struct__mapnik_palette_t
+	if e.palette != nil {
+		// encoding updates the color cache of the palette
+		e.palette.mu.Lock()
+		defer e.palette.mu.Unlock()
+		if e.palette.p == nil {
+			return nil, ErrPaletteFreed
+		}
+		p = e.palette.p
+	} else {
+		var err error
+		p, err = loadPalette(e.paletteFile)
+		if err != nil {
+			return nil, err
+		}
+		defer C.mapnik_palette_free(p)
+	}
+
+	cformat := C.CString(e.format)
+	defer C.free(unsafe.Pointer(cformat))
+	b := C.mapnik_image_to_blob_with_palette(i, cformat, p)
+	if b == nil {
+		return nil, errors.New("mapnik: " + C.GoString(C.mapnik_image_last_error(i)))
+	}
+	defer C.mapnik_image_blob_free(b)
+	return C.GoBytes(unsafe.Pointer(b.ptr), C.int(b.len)), nil
+}
@@ -0,0 +1,125 @@
+package mapnik
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncodeOptionsFormat(t *testing.T) {
+	for _, tc := range []struct {
+		opts   EncodeOptions
+		format string
+	}{
+		{PNGOptions{}, "png32"},
+		{PNGOptions{Paletted: true}, "png8"},
+		{PNGOptions{Paletted: true, Colors: 64, Quantizer: Hextree, Gamma: 2.2}, "png8:c=64:m=h:g=2.2"},
+		{PNGOptions{Compression: 9, Transparency: TransparencyNone}, "png32:z=9:t=0"},
+		{PNGOptions{Compression: -1, Transparency: TransparencyFull}, "png32:z=0:t=2"},
+		{PNGOptions{Paletted: true, Transparency: TransparencyBinary}, "png8:t=1"},
+		{JPEGOptions{}, "jpeg"},
+		{JPEGOptions{Quality: 80}, "jpeg80"},
+		{WebPOptions{}, "webp"},
+		{WebPOptions{Quality: 80, Method: 6, Lossless: true, NoAlpha: true}, "webp:quality=80:method=6:lossless=1:alpha=false"},
+		{TIFFOptions{}, "tiff"},
+		{TIFFOptions{Compression: TIFFLZW, Method: TIFFTiled, TileWidth: 256, TileHeight: 256}, "tiff:compression=lzw:method=tiled:tile_width=256:tile_height=256"},
+	} {
+		if err := tc.opts.Validate(); err != nil {
+			t.Errorf("unexpected error for %#v: %s", tc.opts, err)
+		}
+		if f := tc.opts.Format(); f != tc.format {
+			t.Errorf("unexpected format %q for %#v, expected %q", f, tc.opts, tc.format)
+		}
+	}
+}
+
+func TestEncodeOptionsValidate(t *testing.T) {
+	for _, opts := range []EncodeOptions{
+		PNGOptions{Paletted: true, Colors: 257},
+		PNGOptions{Colors: 16},
+		PNGOptions{Paletted: true, Quantizer: "x"},
+		PNGOptions{Compression: 10},
+		PNGOptions{Transparency: 5},
+		PNGOptions{Paletted: true, Quantizer: Octree, Gamma: 2.0},
+		PNGOptions{Paletted: true, PaletteFile: "does/not/exist.act"},
+		JPEGOptions{Quality: 101},
+		WebPOptions{Quality: -1},
+		WebPOptions{Method: 7},
+		TIFFOptions{Compression: "zip"},
+		TIFFOptions{TileWidth: 256},
+	} {
+		if err := opts.Validate(); err == nil {
+			t.Errorf("expected error for %#v", opts)
+		}
+	}
+}
+
+func TestEncodeWith(t *testing.T) {
+	img := prepareImg(t)
+	b, err := EncodeWith(img, PNGOptions{Compression: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertImageEqual(t, img, decoded)
+
+	if _, err := EncodeWith(img, JPEGOptions{Quality: 200}); err == nil {
+		t.Error("invalid options did not return an error")
+	}
+}
+
+func TestEncodeWithPaletteFile(t *testing.T) {
+	out, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal("unable to create temp dir")
+	}
+	defer os.RemoveAll(out)
+
+	// two color RGBA palette
+	fname := filepath.Join(out, "palette.rgba")
+	if err := os.WriteFile(fname, []byte{255, 0, 0, 255, 0, 0, 255, 255}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := EncodeWith(prepareImg(t), PNGOptions{Paletted: true, PaletteFile: fname})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := img.(*image.Paletted)
+	if !ok {
+		t.Fatalf("expected paletted image, got %T", img)
+	}
+	if len(p.Palette) > 2 {
+		t.Error("unexpected palette size", len(p.Palette))
+	}
+}
+
+func TestRenderEncoding(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	b, err := m.Render(RenderOpts{Encoding: JPEGOptions{Quality: 90}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sniffFormat(b) != "jpeg" {
+		t.Error("expected jpeg output")
+	}
+
+	if _, err := m.Render(RenderOpts{Encoding: PNGOptions{Colors: 16}}); err == nil {
+		t.Error("invalid options did not return an error")
+	}
+}
@@ -0,0 +1,225 @@
+package mapnik
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// georeference describes the position of an image in the SRS of the map.
+type georeference struct {
+	// extent as minx, miny, maxx, maxy
+	extent        [4]float64
+	width, height int
+	srs           string
+	geographic    bool
+}
+
+// pixelSize returns the size of a pixel in SRS units.
+func (g georeference) pixelSize() (float64, float64) {
+	return (g.extent[2] - g.extent[0]) / float64(g.width), (g.extent[3] - g.extent[1]) / float64(g.height)
+}
+
+// worldFile returns the content of an ESRI world file.
+func (g georeference) worldFile() []byte {
+	dx, dy := g.pixelSize()
+	lines := []float64{
+		dx, 0, 0, -dy,
+		// center of the upper left pixel
+		g.extent[0] + dx/2, g.extent[3] - dy/2,
+	}
+	buf := bytes.Buffer{}
+	for _, l := range lines {
+		buf.WriteString(strconv.FormatFloat(l, 'f', -1, 64))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// worldFilePath returns the path of the world file for an image: .pgw for
+// PNG, .jgw for JPEG, .tfw for TIFF and .wld for all other images.
+func worldFilePath(path string) string {
+	ext := filepath.Ext(path)
+	wext := ".wld"
+	switch strings.ToLower(ext) {
+	case ".png":
+		wext = ".pgw"
+	case ".jpg", ".jpeg":
+		wext = ".jgw"
+	case ".tif", ".tiff":
+		wext = ".tfw"
+	}
+	return strings.TrimSuffix(path, ext) + wext
+}
+
+var epsgRe = regexp.MustCompile(`^(?i)\s*(?:\+init=)?epsg:(\d+)\s*$`)
+
+// epsgCode returns the EPSG code of the SRS, or 0 if the SRS is not an EPSG
+// code.
+func epsgCode(srs string) int {
+	match := epsgRe.FindStringSubmatch(srs)
+	if match == nil {
+		return 0
+	}
+	code, err := strconv.Atoi(match[1])
+	if err != nil || code > math.MaxUint16 {
+		return 0
+	}
+	return code
+}
+
+// TIFF tags and GeoKeys, see the GeoTIFF specification.
+const (
+	tiffTypeASCII  = 2
+	tiffTypeShort  = 3
+	tiffTypeDouble = 12
+
+	tagModelPixelScale  = 33550
+	tagModelTiepoint    = 33922
+	tagGeoKeyDirectory  = 34735
+	tagGeoDoubleParams  = 34736
+	tagGeoASCIIParams   = 34737
+	keyGTModelType      = 1024
+	keyGTRasterType     = 1025
+	keyGTCitation       = 1026
+	keyGeographicType   = 2048
+	keyProjectedCSType  = 3072
+	modelTypeProjected  = 1
+	modelTypeGeographic = 2
+	rasterPixelIsArea   = 1
+	userDefined         = 32767
+)
+
+type tiffEntry struct {
+	tag, typ uint16
+	count    uint32
+	// value of the entry, or offset of the value for values larger than
+	// four bytes (byte order of the file)
+	value [4]byte
+}
+
+// geoTIFF adds GeoTIFF tags for g to the first image of the TIFF b. The SRS
+// is stored with its EPSG code, other SRS are stored as user-defined with
+// the SRS as citation.
+func geoTIFF(b []byte, g georeference) ([]byte, error) {
+	if len(b) < 8 {
+		return nil, errors.New("mapnik: invalid TIFF")
+	}
+	var order binary.ByteOrder
+	switch string(b[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, errors.New("mapnik: invalid TIFF byte order")
+	}
+	if order.Uint16(b[2:]) != 42 {
+		return nil, errors.New("mapnik: only classic TIFF supported")
+	}
+	ifd := int(order.Uint32(b[4:]))
+	if ifd+2 > len(b) {
+		return nil, errors.New("mapnik: invalid TIFF IFD offset")
+	}
+	n := int(order.Uint16(b[ifd:]))
+	if ifd+2+n*12+4 > len(b) {
+		return nil, errors.New("mapnik: invalid TIFF IFD")
+	}
+	var entries []tiffEntry
+	for i := 0; i < n; i++ {
+		e := b[ifd+2+i*12:]
+		entry := tiffEntry{tag: order.Uint16(e), typ: order.Uint16(e[2:]), count: order.Uint32(e[4:])}
+		copy(entry.value[:], e[8:12])
+		if entry.tag >= tagModelPixelScale && entry.tag <= tagGeoASCIIParams {
+			continue // replaced below
+		}
+		entries = append(entries, entry)
+	}
+	next := order.Uint32(b[ifd+2+n*12:])
+
+	out := append([]byte(nil), b...)
+	// appends the value of a new entry to out, or stores it in the entry
+	add := func(tag, typ uint16, count int, value []byte) {
+		entry := tiffEntry{tag: tag, typ: typ, count: uint32(count)}
+		if len(value) <= 4 {
+			copy(entry.value[:], value)
+		} else {
+			if len(out)%2 == 1 {
+				out = append(out, 0) // values start at word boundaries
+			}
+			order.PutUint32(entry.value[:], uint32(len(out)))
+			out = append(out, value...)
+		}
+		entries = append(entries, entry)
+	}
+	doubles := func(values ...float64) []byte {
+		buf := make([]byte, len(values)*8)
+		for i, v := range values {
+			order.PutUint64(buf[i*8:], math.Float64bits(v))
+		}
+		return buf
+	}
+
+	dx, dy := g.pixelSize()
+	add(tagModelPixelScale, tiffTypeDouble, 3, doubles(dx, dy, 0))
+	add(tagModelTiepoint, tiffTypeDouble, 6, doubles(0, 0, 0, g.extent[0], g.extent[3], 0))
+
+	modelType := uint16(modelTypeProjected)
+	csKey := uint16(keyProjectedCSType)
+	if g.geographic {
+		modelType = modelTypeGeographic
+		csKey = keyGeographicType
+	}
+	keys := [][4]uint16{
+		{keyGTModelType, 0, 1, modelType},
+		{keyGTRasterType, 0, 1, rasterPixelIsArea},
+	}
+	var ascii string
+	if code := epsgCode(g.srs); code != 0 {
+		keys = append(keys, [4]uint16{csKey, 0, 1, uint16(code)})
+	} else {
+		ascii = g.srs + "|"
+		keys = append(keys,
+			[4]uint16{keyGTCitation, tagGeoASCIIParams, uint16(len(ascii)), 0},
+			[4]uint16{csKey, 0, 1, userDefined},
+		)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i][0] < keys[j][0] })
+	dir := []uint16{1, 1, 0, uint16(len(keys))}
+	for _, k := range keys {
+		dir = append(dir, k[:]...)
+	}
+	buf := make([]byte, len(dir)*2)
+	for i, v := range dir {
+		order.PutUint16(buf[i*2:], v)
+	}
+	add(tagGeoKeyDirectory, tiffTypeShort, len(dir), buf)
+	if ascii != "" {
+		add(tagGeoASCIIParams, tiffTypeASCII, len(ascii)+1, append([]byte(ascii), 0))
+	}
+
+	// write a new IFD with all entries and point the header to the new IFD,
+	// the old IFD remains unused in the file
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	order.PutUint32(out[4:], uint32(len(out)))
+	ifdBuf := make([]byte, 2+len(entries)*12+4)
+	order.PutUint16(ifdBuf, uint16(len(entries)))
+	for i, entry := range entries {
+		e := ifdBuf[2+i*12:]
+		order.PutUint16(e, entry.tag)
+		order.PutUint16(e[2:], entry.typ)
+		order.PutUint32(e[4:], entry.count)
+		copy(e[8:12], entry.value[:])
+	}
+	order.PutUint32(ifdBuf[2+len(entries)*12:], next)
+	return append(out, ifdBuf...), nil
+}
@@ -0,0 +1,193 @@
+package mapnik
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readTIFFTags returns the values of all tags of the first IFD.
+func readTIFFTags(t *testing.T, b []byte) map[uint16][]byte {
+	t.Helper()
+	var order binary.ByteOrder = binary.LittleEndian
+	if string(b[:2]) == "MM" {
+		order = binary.BigEndian
+	}
+	sizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 12: 8}
+	ifd := int(order.Uint32(b[4:]))
+	n := int(order.Uint16(b[ifd:]))
+	tags := map[uint16][]byte{}
+	prev := -1
+	for i := 0; i < n; i++ {
+		e := b[ifd+2+i*12:]
+		tag := order.Uint16(e)
+		if int(tag) <= prev {
+			t.Fatal("tags not sorted", tag)
+		}
+		prev = int(tag)
+		size := sizes[order.Uint16(e[2:])] * int(order.Uint32(e[4:]))
+		if size <= 4 {
+			tags[tag] = e[8 : 8+size]
+		} else {
+			off := int(order.Uint32(e[8:]))
+			tags[tag] = b[off : off+size]
+		}
+	}
+	return tags
+}
+
+func TestGeoTIFF(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		// minimal TIFF with ImageWidth and ImageLength tags
+		b := make([]byte, 8+2+2*12+4)
+		if order == binary.LittleEndian {
+			copy(b, "II")
+		} else {
+			copy(b, "MM")
+		}
+		order.PutUint16(b[2:], 42)
+		order.PutUint32(b[4:], 8)
+		order.PutUint16(b[8:], 2)
+		for i, tag := range []uint16{256, 257} {
+			e := b[10+i*12:]
+			order.PutUint16(e, tag)
+			order.PutUint16(e[2:], 3)
+			order.PutUint32(e[4:], 1)
+			order.PutUint16(e[8:], 10)
+		}
+
+		g := georeference{extent: [4]float64{0, 0, 100, 50}, width: 10, height: 10, srs: "epsg:3857"}
+		result, err := geoTIFF(b, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := readTIFFTags(t, result)
+		assertEqual(t, 5, len(tags))
+		assertEqual(t, uint16(10), order.Uint16(tags[256]))
+
+		doubles := func(b []byte) []float64 {
+			var result []float64
+			for i := 0; i < len(b); i += 8 {
+				result = append(result, math.Float64frombits(order.Uint64(b[i:])))
+			}
+			return result
+		}
+		assertEqual(t, []float64{10, 5, 0}, doubles(tags[tagModelPixelScale]))
+		assertEqual(t, []float64{0, 0, 0, 0, 50, 0}, doubles(tags[tagModelTiepoint]))
+
+		shorts := func(b []byte) []uint16 {
+			var result []uint16
+			for i := 0; i < len(b); i += 2 {
+				result = append(result, order.Uint16(b[i:]))
+			}
+			return result
+		}
+		assertEqual(t, []uint16{
+			1, 1, 0, 3,
+			keyGTModelType, 0, 1, modelTypeProjected,
+			keyGTRasterType, 0, 1, rasterPixelIsArea,
+			keyProjectedCSType, 0, 1, 3857,
+		}, shorts(tags[tagGeoKeyDirectory]))
+
+		g.srs = "+proj=longlat +datum=WGS84"
+		g.geographic = true
+		result, err = geoTIFF(b, g)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags = readTIFFTags(t, result)
+		assertEqual(t, []uint16{
+			1, 1, 0, 4,
+			keyGTModelType, 0, 1, modelTypeGeographic,
+			keyGTRasterType, 0, 1, rasterPixelIsArea,
+			keyGTCitation, tagGeoASCIIParams, uint16(len(g.srs) + 1), 0,
+			keyGeographicType, 0, 1, userDefined,
+		}, shorts(tags[tagGeoKeyDirectory]))
+		assertEqual(t, g.srs+"|\x00", string(tags[tagGeoASCIIParams]))
+	}
+
+	if _, err := geoTIFF([]byte("\x89PNG\r\n\x1a\n"), georeference{}); err == nil {
+		t.Error("expected error for PNG")
+	}
+}
+
+func TestWorldFile(t *testing.T) {
+	g := georeference{extent: [4]float64{1000, 2000, 1100, 2050}, width: 10, height: 5}
+	assertEqual(t, "10\n0\n0\n-10\n1005\n2045\n", string(g.worldFile()))
+
+	assertEqual(t, "/tmp/map.pgw", worldFilePath("/tmp/map.png"))
+	assertEqual(t, "/tmp/map.jgw", worldFilePath("/tmp/map.JPEG"))
+	assertEqual(t, "/tmp/map.tfw", worldFilePath("/tmp/map.tif"))
+	assertEqual(t, "/tmp/map.wld", worldFilePath("/tmp/map.webp"))
+	assertEqual(t, "map.wld", worldFilePath("map"))
+}
+
+func TestEPSGCode(t *testing.T) {
+	assertEqual(t, 4326, epsgCode("epsg:4326"))
+	assertEqual(t, 3857, epsgCode("+init=EPSG:3857"))
+	assertEqual(t, 0, epsgCode("+proj=longlat +datum=WGS84"))
+	assertEqual(t, 0, epsgCode("epsg:99999999"))
+}
+
+func TestRenderGeoreference(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+	extent := m.Extent()
+
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "map.png")
+	if err := m.RenderToFile(RenderOpts{Format: "png", Georeference: true}, fname); err != nil {
+		t.Fatal(err)
+	}
+	wld, err := os.ReadFile(filepath.Join(dir, "map.pgw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(wld)), "\n")
+	assertEqual(t, 6, len(lines))
+
+	// no world file if the image can't be written
+	fname = filepath.Join(dir, "dir.png")
+	if err := os.Mkdir(fname, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.RenderToFile(RenderOpts{Format: "png", Georeference: true}, fname); err == nil {
+		t.Fatal("expected error for directory")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "dir.pgw")); !os.IsNotExist(err) {
+		t.Error("unexpected world file for failed rendering", err)
+	}
+
+	fname = filepath.Join(dir, "map.tiff")
+	if err := m.RenderToFile(RenderOpts{Format: "tiff", Georeference: true}, fname); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "map.tfw")); !os.IsNotExist(err) {
+		t.Error("unexpected world file for GeoTIFF", err)
+	}
+	b, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 800, img.Bounds().Dx())
+	tags := readTIFFTags(t, b)
+	tiepoint := tags[tagModelTiepoint]
+	var order binary.ByteOrder = binary.LittleEndian
+	if bytes.HasPrefix(b, []byte("MM")) {
+		order = binary.BigEndian
+	}
+	assertEqual(t, extent[0], math.Float64frombits(order.Uint64(tiepoint[24:])))
+	assertEqual(t, extent[3], math.Float64frombits(order.Uint64(tiepoint[32:])))
+}
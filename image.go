@@ -0,0 +1,182 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"runtime"
+	"unsafe"
+)
+
+// MaxDecodePixels limits the size (width * height) of images that are
+// accepted by Decode and DecodeImage. Set to 0 to disable the limit.
+var MaxDecodePixels = 8192 * 8192
+
+// ErrImageFreed is returned by Image.Encode and Image.EncodeWith after Free.
+var ErrImageFreed = errors.New("mapnik: image already freed")
+
+// Image is an unencoded image in Mapnik's internal RGBA format. Call Free
+// to release the image. Images that are not freed are released by the
+// garbage collector.
+type Image struct {
+	i *C.struct__mapnik_image_t
+}
+
+func newImage(i *C.struct__mapnik_image_t) *Image {
+	img := &Image{i: i}
+	runtime.SetFinalizer(img, (*Image).Free)
+	return img
+}
+
+// Free deallocates the image. Free can be called multiple times.
+func (i *Image) Free() {
+	if i.i == nil {
+		return
+	}
+	C.mapnik_image_free(i.i)
+	i.i = nil
+	runtime.SetFinalizer(i, nil)
+}
+
+// Bounds returns the size of the image. The size is empty after Free.
+func (i *Image) Bounds() image.Rectangle {
+	defer runtime.KeepAlive(i)
+	return image.Rect(0, 0, int(C.mapnik_image_width(i.i)), int(C.mapnik_image_height(i.i)))
+}
+
+// NRGBA returns a copy of the image as *image.NRGBA. The image is empty
+// after Free.
+func (i *Image) NRGBA() *image.NRGBA {
+	defer runtime.KeepAlive(i)
+	return imageToNRGBA(i.i)
+}
+
+// Encode returns the image encoded in the given format ('png256', 'jpeg80', etc.).
+func (i *Image) Encode(format string) ([]byte, error) {
+	if i.i == nil {
+		return nil, ErrImageFreed
+	}
+	defer runtime.KeepAlive(i)
+	return encoding{format: format}.encode(i.i)
+}
+
+// Decode decodes a PNG, JPEG, WebP or TIFF image with Mapniks image readers.
+func Decode(b []byte) (*image.NRGBA, error) {
+	i, err := decodeImage(b)
+	if err != nil {
+		return nil, err
+	}
+	defer C.mapnik_image_free(i)
+	return imageToNRGBA(i), nil
+}
+
+// DecodeImage decodes a PNG, JPEG, WebP or TIFF image with Mapniks image
+// readers. The returned Image can be re-encoded without further conversion.
+func DecodeImage(b []byte) (*Image, error) {
+	i, err := decodeImage(b)
+	if err != nil {
+		return nil, err
+	}
+	return newImage(i), nil
+}
+
+func decodeImage(b []byte) (*C.struct__mapnik_image_t, error) {
+	if sniffFormat(b) == "" {
+		return nil, errors.New("mapnik: unsupported image format")
+	}
+	maxPixels := MaxDecodePixels
+	if maxPixels < 0 {
+		maxPixels = 0
+	}
+	i := C.mapnik_image_from_blob((*C.char)(unsafe.Pointer(&b[0])), C.size_t(len(b)), C.size_t(maxPixels))
+	if e := C.mapnik_image_last_error(i); e != nil {
+		err := errors.New("mapnik: decoding image: " + C.GoString(e))
+		C.mapnik_image_free(i)
+		return nil, err
+	}
+	return i, nil
+}
+
+// sniffFormat returns the image format of b based on the magic number of
+// the file, or an empty string for unsupported formats.
+func sniffFormat(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "png"
+	case bytes.HasPrefix(b, []byte("\xff\xd8\xff")):
+		return "jpeg"
+	case len(b) >= 12 && bytes.Equal(b[0:4], []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WEBP")):
+		return "webp"
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return "tiff"
+	}
+	return ""
+}
+
+func imageToNRGBA(i *C.struct__mapnik_image_t) *image.NRGBA {
+	width := int(C.mapnik_image_width(i))
+	height := int(C.mapnik_image_height(i))
+	size := 0
+	raw := C.mapnik_image_to_raw(i, (*C.size_t)(unsafe.Pointer(&size)))
+	return &image.NRGBA{
+		Pix:    C.GoBytes(unsafe.Pointer(raw), C.int(size)),
+		Stride: width * 4,
+		Rect:   image.Rect(0, 0, width, height),
+	}
+}
+
+func encodeImage(i *C.struct__mapnik_image_t, format string) ([]byte, error) {
+	cformat := C.CString(format)
+	defer C.free(unsafe.Pointer(cformat))
+	b := C.mapnik_image_to_blob(i, cformat)
+	if b == nil {
+		return nil, errors.New("mapnik: " + C.GoString(C.mapnik_image_last_error(i)))
+	}
+	defer C.mapnik_image_blob_free(b)
+	return C.GoBytes(unsafe.Pointer(b.ptr), C.int(b.len)), nil
+}
+
+// Compare returns the number of pixels that differ between a and b, similar
+// to Mapnik's image::compare. Pixels are different if one of the color
+// channels differs by more than threshold. The alpha channel is only compared
+// if alpha is true.
+//
+// The returned diffImage marks all different pixels in opaque red. Images of
+// different sizes return the number of pixels of a and a nil diffImage.
+func Compare(a, b image.Image, threshold int, alpha bool) (diffPixels int, diffImage *image.NRGBA) {
+	if a.Bounds().Dx() != b.Bounds().Dx() || a.Bounds().Dy() != b.Bounds().Dy() {
+		return a.Bounds().Dx() * a.Bounds().Dy(), nil
+	}
+	na := toNRGBA(a)
+	nb := toNRGBA(b)
+	width, height := na.Rect.Dx(), na.Rect.Dy()
+	diffImage = image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		pa := na.Pix[y*na.Stride : y*na.Stride+width*4]
+		pb := nb.Pix[y*nb.Stride : y*nb.Stride+width*4]
+		pd := diffImage.Pix[y*diffImage.Stride:]
+		for i := 0; i < width*4; i += 4 {
+			if channelDiff(pa[i+0], pb[i+0], threshold) ||
+				channelDiff(pa[i+1], pb[i+1], threshold) ||
+				channelDiff(pa[i+2], pb[i+2], threshold) ||
+				(alpha && channelDiff(pa[i+3], pb[i+3], threshold)) {
+				diffPixels++
+				pd[i+0] = 0xff
+				pd[i+3] = 0xff
+			}
+		}
+	}
+	return diffPixels, diffImage
+}
+
+func channelDiff(a, b uint8, threshold int) bool {
+	d := int(a) - int(b)
+	if d < 0 {
+		d = -d
+	}
+	return d > threshold
+}
@@ -0,0 +1,196 @@
+package mapnik
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	img := prepareImg(t)
+
+	for _, format := range []string{"png", "png32", "tiff", "webp:lossless=1"} {
+		t.Run(format, func(t *testing.T) {
+			b, err := Encode(img, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := Decode(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertImageEqual(t, img, decoded)
+		})
+	}
+}
+
+func TestDecodeJPEG(t *testing.T) {
+	img := prepareImg(t)
+	b, err := Encode(img, "jpeg90")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertImageSimilar(t, img, decoded, 16, 0.01)
+}
+
+func TestDecodeImage(t *testing.T) {
+	b, err := os.ReadFile("test/encode_test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeImage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Free()
+
+	assertEqual(t, prepareImg(t).Bounds(), img.Bounds())
+
+	png, err := img.Encode("png32")
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _, err := image.Decode(bytes.NewReader(png))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertImageEqual(t, prepareImg(t), decoded)
+}
+
+func TestImageFree(t *testing.T) {
+	b, err := Encode(prepareImg(t), "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := DecodeImage(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img.Free()
+	img.Free()
+	if _, err := img.Encode("png"); err != ErrImageFreed {
+		t.Error("unexpected error", err)
+	}
+	if _, err := img.EncodeWith(PNGOptions{}); err != ErrImageFreed {
+		t.Error("unexpected error", err)
+	}
+	assertEqual(t, image.Rectangle{}, img.Bounds())
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, b := range [][]byte{
+		nil,
+		[]byte("GIF89a"),
+		[]byte("\x89PNG\r\n\x1a\n-truncated"),
+	} {
+		if _, err := Decode(b); err == nil {
+			t.Errorf("decoding %q did not return an error", b)
+		}
+	}
+}
+
+func TestDecodeMaxPixels(t *testing.T) {
+	b, err := os.ReadFile("test/encode_test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(n int) { MaxDecodePixels = n }(MaxDecodePixels)
+
+	MaxDecodePixels = 100
+	if _, err := Decode(b); err == nil {
+		t.Error("large image did not return an error")
+	}
+	MaxDecodePixels = 0
+	if _, err := Decode(b); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSniffFormat(t *testing.T) {
+	for _, tc := range []struct {
+		data   string
+		format string
+	}{
+		{"\x89PNG\r\n\x1a\n....", "png"},
+		{"\xff\xd8\xff\xe0", "jpeg"},
+		{"RIFF\x00\x00\x00\x00WEBPVP8 ", "webp"},
+		{"RIFF\x00\x00\x00\x00WAVE", ""},
+		{"II*\x00", "tiff"},
+		{"MM\x00*", "tiff"},
+		{"GIF89a", ""},
+		{"", ""},
+	} {
+		if f := sniffFormat([]byte(tc.data)); f != tc.format {
+			t.Errorf("unexpected format %q for %q", f, tc.data)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	b := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	a.Set(0, 0, color.NRGBA{100, 100, 100, 255})
+	b.Set(0, 0, color.NRGBA{105, 100, 100, 255})
+	a.Set(1, 1, color.NRGBA{100, 100, 100, 255})
+	b.Set(1, 1, color.NRGBA{100, 100, 100, 200})
+
+	n, diff := Compare(a, b, 0, true)
+	assertEqual(t, 2, n)
+	assertEqual(t, color.NRGBA{255, 0, 0, 255}, diff.At(0, 0))
+	assertEqual(t, color.NRGBA{255, 0, 0, 255}, diff.At(1, 1))
+	assertEqual(t, color.NRGBA{0, 0, 0, 0}, diff.At(1, 0))
+
+	n, _ = Compare(a, b, 0, false)
+	assertEqual(t, 1, n)
+
+	n, _ = Compare(a, b, 5, true)
+	assertEqual(t, 1, n)
+
+	n, diff = Compare(a, image.NewNRGBA(image.Rect(0, 0, 2, 2)), 0, true)
+	assertEqual(t, 8, n)
+	if diff != nil {
+		t.Error("expected no diff image for different sizes")
+	}
+}
+
+// assertImageSimilar fails if more than maxDiff (ratio of all pixels) differ
+// between expected and actual. The expected, actual and diff images are
+// written to testdata/failed/ on failure.
+func assertImageSimilar(t *testing.T, expected, actual image.Image, threshold int, maxDiff float64) {
+	t.Helper()
+	n, diff := Compare(expected, actual, threshold, true)
+	if float64(n) <= maxDiff*float64(expected.Bounds().Dx()*expected.Bounds().Dy()) {
+		return
+	}
+
+	dir := filepath.Join("testdata", "failed")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	name := strings.ReplaceAll(t.Name(), "/", "_")
+	imgs := map[string]image.Image{"expected": expected, "actual": actual}
+	if diff != nil {
+		imgs["diff"] = diff
+	}
+	for suffix, img := range imgs {
+		fname := filepath.Join(dir, name+"-"+suffix+".png")
+		f, err := os.Create(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := png.Encode(f, img); err != nil {
+			t.Error(err)
+		}
+		f.Close()
+	}
+	t.Fatalf("%d pixels differ, see %s/%s-*.png", n, dir, name)
+}
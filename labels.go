@@ -0,0 +1,98 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Label is a label or symbol that was placed by the renderer. Labels
+// include the text of text and shield symbolizers and the symbols of point,
+// marker and shield symbolizers, as long as they are not rendered with
+// ignore-placement.
+type Label struct {
+	Layer string
+	// FeatureID of the labeled feature. Zero if the feature is unknown,
+	// e.g. for layers with cache-features.
+	FeatureID int64
+	// Box of the label in pixel as minx, miny, maxx, maxy. Labels in the
+	// buffer of the map are partially or fully outside of the image.
+	Box [4]float64
+	// Text of the label. Empty for symbols.
+	Text string
+}
+
+func placedLabels(l *C.struct__mapnik_labels_t) []Label {
+	labels := make([]Label, int(C.mapnik_labels_count(l)))
+	for i := range labels {
+		label := &labels[i]
+		label.Layer = C.GoString(C.mapnik_labels_layer(l, C.size_t(i)))
+		label.FeatureID = int64(C.mapnik_labels_feature_id(l, C.size_t(i)))
+		var x0, y0, x1, y1 C.double
+		C.mapnik_labels_box(l, C.size_t(i), &x0, &y0, &x1, &y1)
+		label.Box = [4]float64{float64(x0), float64(y0), float64(x1), float64(y1)}
+		label.Text = C.GoString(C.mapnik_labels_text(l, C.size_t(i)))
+	}
+	return labels
+}
+
+// ErrLabelDetectorFreed is returned by renderings with a LabelDetector
+// after Free.
+var ErrLabelDetectorFreed = errors.New("mapnik: label detector already freed")
+
+// LabelDetector detects collisions of labels. Each rendering uses a new
+// detector by default. Pass a LabelDetector with RenderOpts to place labels
+// of multiple renderings without overlaps, e.g. for a base map and an
+// overlay that are rendered separately.
+//
+// A LabelDetector is not safe for concurrent use. Call Free to release the
+// detector. Detectors that are not freed are released by the garbage
+// collector.
+type LabelDetector struct {
+	d *C.struct__mapnik_label_detector_t
+}
+
+// NewLabelDetector initializes a new LabelDetector for maps with the given
+// size and buffer size in pixel. Labels outside of the size and buffer are
+// not placed, so use the same size and buffer size as the rendered maps.
+func NewLabelDetector(width, height, bufferSize int) *LabelDetector {
+	b := float64(bufferSize)
+	d := &LabelDetector{
+		d: C.mapnik_label_detector(C.double(-b), C.double(-b), C.double(float64(width)+b), C.double(float64(height)+b)),
+	}
+	runtime.SetFinalizer(d, (*LabelDetector).Free)
+	return d
+}
+
+// Reserve adds a box as minx, miny, maxx, maxy in pixel to the detector. No
+// labels are placed in reserved boxes, e.g. for a logo or a legend on top of
+// the map.
+func (d *LabelDetector) Reserve(box [4]float64) {
+	if d.d == nil {
+		return
+	}
+	defer runtime.KeepAlive(d)
+	C.mapnik_label_detector_insert(d.d, C.double(box[0]), C.double(box[1]), C.double(box[2]), C.double(box[3]))
+}
+
+// Clear removes all labels and reserved boxes.
+func (d *LabelDetector) Clear() {
+	if d.d == nil {
+		return
+	}
+	defer runtime.KeepAlive(d)
+	C.mapnik_label_detector_clear(d.d)
+}
+
+// Free deallocates the detector. Free can be called multiple times.
+func (d *LabelDetector) Free() {
+	if d.d == nil {
+		return
+	}
+	C.mapnik_label_detector_free(d.d)
+	d.d = nil
+	runtime.SetFinalizer(d, nil)
+}
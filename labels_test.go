@@ -0,0 +1,202 @@
+package mapnik
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const pointStylesheet = `<Map srs="epsg:4326">
+    <Style name="point">
+        <Rule>
+            <PointSymbolizer />
+        </Rule>
+    </Style>
+    <Layer name="layerA" srs="epsg:4326">
+        <StyleName>point</StyleName>
+        <Datasource>
+            <Parameter name="file">map.geojson</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+    <Layer name="layerB" srs="epsg:4326">
+        <StyleName>point</StyleName>
+        <Datasource>
+            <Parameter name="file">map.geojson</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+</Map>`
+
+func loadPointMap(t *testing.T) *Map {
+	t.Helper()
+	fname := filepath.Join(t.TempDir(), "style.xml")
+	if err := os.WriteFile(fname, []byte(pointStylesheet), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testDir, err := filepath.Abs("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	if err := m.LoadWithOpts(fname, LoadOpts{BasePath: testDir}); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+	return m
+}
+
+func TestRenderCollectLabels(t *testing.T) {
+	m := loadPointMap(t)
+	defer m.Free()
+
+	var labels []Label
+	if _, err := m.Render(RenderOpts{Format: "png", CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	// point of layerB collides with the point of layerA
+	if len(labels) != 1 {
+		t.Fatal("unexpected labels", labels)
+	}
+	l := labels[0]
+	assertEqual(t, "layerA", l.Layer)
+	assertEqual(t, "", l.Text)
+	if l.FeatureID == 0 {
+		t.Error("missing feature id")
+	}
+	if l.Box[0] <= 0 || l.Box[1] <= 0 || l.Box[2] >= 800 || l.Box[3] >= 600 || l.Box[0] >= l.Box[2] {
+		t.Error("unexpected box", l.Box)
+	}
+
+	// labels are collected for each rendering
+	if _, err := m.RenderImage(RenderOpts{CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(labels))
+}
+
+func TestRenderCollectLabelsAllowOverlap(t *testing.T) {
+	fname := filepath.Join(t.TempDir(), "style.xml")
+	style := strings.Replace(pointStylesheet, "<PointSymbolizer />", `<PointSymbolizer allow-overlap="true" />`, 1)
+	if err := os.WriteFile(fname, []byte(style), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testDir, err := filepath.Abs("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := New()
+	defer m.Free()
+	if err := m.LoadWithOpts(fname, LoadOpts{BasePath: testDir}); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	var labels []Label
+	if _, err := m.RenderImage(RenderOpts{CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	// identical points of both layers are recorded
+	if len(labels) != 2 {
+		t.Fatal("unexpected labels", labels)
+	}
+	assertEqual(t, "layerA", labels[0].Layer)
+	assertEqual(t, "layerB", labels[1].Layer)
+	assertEqual(t, labels[0].Box, labels[1].Box)
+}
+
+func TestRenderLabelDetector(t *testing.T) {
+	m := loadPointMap(t)
+	defer m.Free()
+
+	d := NewLabelDetector(800, 600, 0)
+	defer d.Free()
+
+	var labels []Label
+	if _, err := m.RenderImage(RenderOpts{LabelDetector: d, CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(labels))
+
+	// point collides with the point of the previous rendering
+	if _, err := m.RenderImage(RenderOpts{LabelDetector: d, CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 0, len(labels))
+
+	d.Clear()
+	d.Reserve([4]float64{0, 0, 800, 600})
+	if _, err := m.RenderImage(RenderOpts{LabelDetector: d, CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 0, len(labels))
+
+	d.Clear()
+	if _, err := m.RenderImage(RenderOpts{LabelDetector: d, CollectLabels: &labels}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(labels))
+
+	d.Free()
+	d.Free()
+	if _, err := m.RenderImage(RenderOpts{LabelDetector: d}); err != ErrLabelDetectorFreed {
+		t.Error("expected ErrLabelDetectorFreed, got", err)
+	}
+}
+
+func BenchmarkRenderCollectLabels(b *testing.B) {
+	dir := b.TempDir()
+	// grid of 60x50 points with a symbol for each point
+	features := make([]string, 0, 60*50)
+	for x := 0; x < 60; x++ {
+		for y := 0; y < 50; y++ {
+			features = append(features, fmt.Sprintf(
+				`{"type": "Feature", "id": %d, "properties": {}, "geometry": {"type": "Point", "coordinates": [%d, %d]}}`,
+				len(features)+1, x, y))
+		}
+	}
+	geojson := `{"type": "FeatureCollection", "features": [` + strings.Join(features, ",") + `]}`
+	if err := os.WriteFile(filepath.Join(dir, "points.geojson"), []byte(geojson), 0644); err != nil {
+		b.Fatal(err)
+	}
+	style := `<Map srs="epsg:4326">
+    <Style name="point">
+        <Rule>
+            <PointSymbolizer />
+        </Rule>
+    </Style>
+    <Layer name="points" srs="epsg:4326">
+        <StyleName>point</StyleName>
+        <Datasource>
+            <Parameter name="file">points.geojson</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+</Map>`
+	fname := filepath.Join(dir, "style.xml")
+	if err := os.WriteFile(fname, []byte(style), 0644); err != nil {
+		b.Fatal(err)
+	}
+	m := NewSized(1200, 1000)
+	defer m.Free()
+	if err := m.Load(fname); err != nil {
+		b.Fatal(err)
+	}
+	m.ZoomTo(-0.5, -0.5, 59.5, 49.5)
+
+	var labels []Label
+	if _, err := m.RenderImage(RenderOpts{CollectLabels: &labels}); err != nil {
+		b.Fatal(err)
+	}
+	if len(labels) != 3000 {
+		b.Fatal("unexpected number of labels", len(labels))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := m.RenderImage(RenderOpts{CollectLabels: &labels}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"path"
+	"regexp"
+	"runtime"
+	"sort"
+)
+
+// LayerInfo contains the metadata of a layer for LayerInfoSelectors.
+type LayerInfo struct {
+	Name   string
+	Active bool
+	// DatasourceType is the type parameter of the datasource ("shape",
+	// "postgis", etc.).
+	DatasourceType string
+	// MinScale and MaxScale are the minimum-scale-denominator and
+	// maximum-scale-denominator of the layer. MaxScale is math.MaxFloat64 if
+	// not set.
+	MinScale float64
+	MaxScale float64
+	Styles   []string
+	// Groups are the groups of the layer from SetLayerGroup. The group-by
+	// attribute of Mapnik layers is not a group, as it groups the features
+	// of the layer by a feature attribute.
+	Groups []string
+}
+
+// LayerInfoSelector is a LayerSelector with access to the metadata of
+// each layer.
+type LayerInfoSelector interface {
+	SelectLayer(info LayerInfo) Status
+}
+
+type LayerInfoSelectorFunc func(LayerInfo) Status
+
+func (f LayerInfoSelectorFunc) SelectLayer(info LayerInfo) Status {
+	return f(info)
+}
+
+// SelectLayer calls f with the name of the layer. SelectorFuncs are
+// LayerInfoSelectors.
+func (f SelectorFunc) SelectLayer(info LayerInfo) Status {
+	return f(info.Name)
+}
+
+// GlobSelector includes all layers with names that match one of the
+// patterns and excludes all other layers. See path.Match for the pattern
+// syntax. Returns an error for invalid patterns.
+func GlobSelector(patterns ...string) (SelectorFunc, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, err
+		}
+	}
+	return func(layername string) Status {
+		if matchAny(patterns, layername) {
+			return Include
+		}
+		return Exclude
+	}, nil
+}
+
+// RegexpSelector includes all layers with names that match re and
+// excludes all other layers.
+func RegexpSelector(re *regexp.Regexp) SelectorFunc {
+	return func(layername string) Status {
+		if re.MatchString(layername) {
+			return Include
+		}
+		return Exclude
+	}
+}
+
+// GroupSelector includes all layers of the groups and excludes all other
+// layers.
+func GroupSelector(groups ...string) LayerInfoSelectorFunc {
+	return func(info LayerInfo) Status {
+		for _, g := range info.Groups {
+			for _, group := range groups {
+				if g == group {
+					return Include
+				}
+			}
+		}
+		return Exclude
+	}
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetLayerGroup defines a group of layers. Layers are names or patterns
+// (see GlobSelector). Groups replace previous groups with the same name
+// and are included in LayerInfo.Groups.
+func (m *Map) SetLayerGroup(group string, layers ...string) {
+	if m.groups == nil {
+		m.groups = map[string][]string{}
+	}
+	m.groups[group] = append([]string(nil), layers...)
+}
+
+// LayerInfos returns the metadata of all layers.
+func (m *Map) LayerInfos() []LayerInfo {
+	if m.m == nil {
+		return nil
+	}
+	defer runtime.KeepAlive(m)
+	n := int(C.mapnik_map_layer_count(m.m))
+	infos := make([]LayerInfo, n)
+	for i := range infos {
+		infos[i] = m.layerInfo(i)
+	}
+	return infos
+}
+
+func (m *Map) layerInfo(idx int) LayerInfo {
+	i := C.size_t(idx)
+	info := LayerInfo{
+		Name:           C.GoString(C.mapnik_map_layer_name(m.m, i)),
+		Active:         C.mapnik_map_layer_is_active(m.m, i) == 1,
+		DatasourceType: C.GoString(C.mapnik_map_layer_datasource_type(m.m, i)),
+		MinScale:       float64(C.mapnik_map_layer_min_scale(m.m, i)),
+		MaxScale:       float64(C.mapnik_map_layer_max_scale(m.m, i)),
+	}
+	n := int(C.mapnik_map_layer_style_count(m.m, i))
+	for s := 0; s < n; s++ {
+		info.Styles = append(info.Styles, C.GoString(C.mapnik_map_layer_style(m.m, i, C.size_t(s))))
+	}
+
+	groups := make([]string, 0, len(m.groups))
+	for group, layers := range m.groups {
+		if matchAny(layers, info.Name) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	if len(groups) > 0 {
+		info.Groups = groups
+	}
+	return info
+}
+
+// SelectLayersInfo enables/disables single layers, like SelectLayers.
+// The selector gets called with the metadata of each layer.
+// Returns true if at least one layer was included (or set to default).
+func (m *Map) SelectLayersInfo(selector LayerInfoSelector) bool {
+	if m.m == nil {
+		return false
+	}
+	defer runtime.KeepAlive(m)
+	m.storeLayerStatus()
+	selected := false
+	n := C.mapnik_map_layer_count(m.m)
+	for i := 0; i < int(n); i++ {
+		switch selector.SelectLayer(m.layerInfo(i)) {
+		case Include:
+			selected = true
+			C.mapnik_map_layer_set_active(m.m, C.size_t(i), 1)
+		case Exclude:
+			C.mapnik_map_layer_set_active(m.m, C.size_t(i), 0)
+		case Default:
+			selected = true
+		}
+	}
+	return selected
+}
+
+// MatchLayers returns the names of all layers that the selector includes,
+// and of all active layers that the selector keeps at the default status.
+// Use the result as RenderOpts.Layers to render the selection without
+// changing the map. The result is an empty, non-nil slice if no layer
+// matches, so that RenderOpts.Layers renders no layers.
+func (m *Map) MatchLayers(selector LayerInfoSelector) []string {
+	if m.m == nil {
+		return nil
+	}
+	defer runtime.KeepAlive(m)
+	layers := []string{}
+	n := int(C.mapnik_map_layer_count(m.m))
+	for i := 0; i < n; i++ {
+		info := m.layerInfo(i)
+		switch selector.SelectLayer(info) {
+		case Include:
+			layers = append(layers, info.Name)
+		case Default:
+			if info.Active {
+				layers = append(layers, info.Name)
+			}
+		}
+	}
+	return layers
+}
+
+// VisibleLayers returns the names of all active layers that are visible at
+// the scale denominator. Layers are visible if the scale denominator is
+// within the minimum-scale-denominator and maximum-scale-denominator of the
+// layer, and within the scale range of at least one rule of the layer
+// styles. Use ScaleDenominator for the current map, multiplied by the scale
+// factor for renderings with RenderOpts.ScaleFactor.
+func (m *Map) VisibleLayers(scaleDenominator float64) []string {
+	if m.m == nil {
+		return nil
+	}
+	defer runtime.KeepAlive(m)
+	var layers []string
+	n := int(C.mapnik_map_layer_count(m.m))
+	for i := 0; i < n; i++ {
+		if C.mapnik_map_layer_is_active(m.m, C.size_t(i)) == 1 &&
+			C.mapnik_map_layer_is_visible(m.m, C.size_t(i), C.double(scaleDenominator)) == 1 {
+			layers = append(layers, C.GoString(C.mapnik_map_layer_name(m.m, C.size_t(i))))
+		}
+	}
+	return layers
+}
@@ -0,0 +1,140 @@
+package mapnik
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestLayerInfos(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.SetLayerGroup("ab", "layerA", "layerB")
+	m.SetLayerGroup("all", "layer*")
+
+	infos := m.LayerInfos()
+	assertEqual(t, 4, len(infos))
+	assertEqual(t, LayerInfo{
+		Name:           "layerA",
+		Active:         true,
+		DatasourceType: "geojson",
+		MinScale:       0,
+		MaxScale:       math.MaxFloat64,
+		Styles:         []string{"styleA"},
+		Groups:         []string{"ab", "all"},
+	}, infos[0])
+	assertEqual(t, []string{"all"}, infos[2].Groups)
+	assertEqual(t, false, infos[3].Active)
+}
+
+func TestLayerSelectors(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.SetLayerGroup("ab", "layerA", "layerB")
+
+	glob, err := GlobSelector("layer[BD]", "unknown-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GlobSelector("layer["); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+
+	for _, tc := range []struct {
+		selector LayerInfoSelector
+		expected []string
+	}{
+		{glob, []string{"layerB", "layerD"}},
+		{RegexpSelector(regexp.MustCompile(`^layer[AC]$`)), []string{"layerA", "layerC"}},
+		{GroupSelector("ab"), []string{"layerA", "layerB"}},
+		{GroupSelector("unknown"), []string{}},
+		{LayerInfoSelectorFunc(func(info LayerInfo) Status {
+			if info.Styles[0] == "styleB" {
+				return Exclude
+			}
+			return Default
+		}), []string{"layerA", "layerC"}},
+	} {
+		assertEqual(t, tc.expected, m.MatchLayers(tc.selector))
+	}
+	assertEqual(t, []bool{true, true, true, false}, m.currentLayerStatus())
+
+	if selected := m.SelectLayersInfo(GroupSelector("ab")); !selected {
+		t.Error("unexpected SelectLayersInfo result", selected)
+	}
+	assertEqual(t, []bool{true, true, false, false}, m.currentLayerStatus())
+	m.ResetLayers()
+	assertEqual(t, []bool{true, true, true, false}, m.currentLayerStatus())
+}
+
+const scaleStylesheet = `<Map srs="epsg:4326">
+    <Style name="all">
+        <Rule>
+            <PolygonSymbolizer />
+        </Rule>
+    </Style>
+    <Style name="small">
+        <Rule>
+            <MinScaleDenominator>1000000</MinScaleDenominator>
+            <PolygonSymbolizer />
+        </Rule>
+    </Style>
+    <Style name="large">
+        <Rule>
+            <MaxScaleDenominator>100000</MaxScaleDenominator>
+            <PolygonSymbolizer />
+        </Rule>
+    </Style>
+    <Style name="tiny">
+        <Rule>
+            <MinScaleDenominator>10000000</MinScaleDenominator>
+            <PolygonSymbolizer />
+        </Rule>
+    </Style>
+    <Layer name="layerA" maximum-scale-denominator="1000000">
+        <StyleName>all</StyleName>
+    </Layer>
+    <Layer name="layerB">
+        <StyleName>small</StyleName>
+    </Layer>
+    <Layer name="layerC">
+        <StyleName>large</StyleName>
+        <StyleName>tiny</StyleName>
+    </Layer>
+    <Layer name="layerD">
+        <StyleName>undefined</StyleName>
+    </Layer>
+</Map>`
+
+func TestVisibleLayers(t *testing.T) {
+	m := New()
+	defer m.Free()
+	fname := filepath.Join(t.TempDir(), "style.xml")
+	if err := os.WriteFile(fname, []byte(scaleStylesheet), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load(fname); err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, []string{"layerA", "layerC"}, m.VisibleLayers(50000))
+	assertEqual(t, []string{"layerA"}, m.VisibleLayers(500000))
+	assertEqual(t, []string{"layerB"}, m.VisibleLayers(5000000))
+	assertEqual(t, []string{"layerB", "layerC"}, m.VisibleLayers(20000000))
+
+	m.SelectLayers(SelectorFunc(func(layer string) Status {
+		if layer == "layerB" {
+			return Exclude
+		}
+		return Default
+	}))
+	assertEqual(t, []string{"layerC"}, m.VisibleLayers(20000000))
+}
@@ -0,0 +1,110 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"image"
+	"image/draw"
+	"runtime"
+	"unsafe"
+)
+
+// LegendOpts defines options for RenderLegend.
+type LegendOpts struct {
+	// Width and Height of each swatch in pixel. Defaults to 32x16.
+	Width  int
+	Height int
+	// Scale only includes layers and rules that are visible at this scale
+	// denominator. All rules of the active layers are included if zero.
+	Scale float64
+	// ScaleFactor for line widths, marker sizes, etc. of the swatches.
+	ScaleFactor float64
+}
+
+// LegendItem is the swatch of a single style rule.
+type LegendItem struct {
+	Layer string
+	Style string
+	// Rule is the name of the rule. Empty for rules without a name.
+	Rule string
+	// Filter of the rule as a Mapnik expression, "else" for ElseFilter
+	// and "also" for AlsoFilter rules.
+	Filter string
+	Image  *image.NRGBA
+}
+
+// Legend contains the swatches of all rules in the order of the layers and
+// styles of the map.
+type Legend struct {
+	Items []LegendItem
+}
+
+// RenderLegend renders a swatch for each rule of the active layers.
+//
+// Each swatch is rendered with the symbolizers of the rule and a single
+// feature: a polygon for rules with polygon symbolizers, a line for rules
+// with line symbolizers and a point in the center for all other rules.
+// Swatches are rendered with the same renderer as Render. Filters are
+// ignored and the feature has no attributes, so text and shield symbolizers
+// do not render a label.
+func (m *Map) RenderLegend(opts LegendOpts) (*Legend, error) {
+	if m.m == nil {
+		return nil, ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	if opts.Width <= 0 {
+		opts.Width = 32
+	}
+	if opts.Height <= 0 {
+		opts.Height = 16
+	}
+	scaleFactor := opts.ScaleFactor
+	if scaleFactor == 0.0 {
+		scaleFactor = 1.0
+	}
+	l := C.mapnik_map_legend(m.m, C.uint(opts.Width), C.uint(opts.Height), C.double(opts.Scale), C.double(scaleFactor))
+	if l == nil {
+		return nil, m.lastError()
+	}
+	defer C.mapnik_legend_free(l)
+
+	n := int(C.mapnik_legend_count(l))
+	legend := &Legend{Items: make([]LegendItem, n)}
+	for i := 0; i < n; i++ {
+		item := &legend.Items[i]
+		item.Layer = C.GoString(C.mapnik_legend_layer(l, C.size_t(i)))
+		item.Style = C.GoString(C.mapnik_legend_style(l, C.size_t(i)))
+		item.Rule = C.GoString(C.mapnik_legend_rule(l, C.size_t(i)))
+		item.Filter = C.GoString(C.mapnik_legend_filter(l, C.size_t(i)))
+		size := 0
+		raw := C.mapnik_legend_image_raw(l, C.size_t(i), (*C.size_t)(unsafe.Pointer(&size)))
+		item.Image = &image.NRGBA{
+			Pix:    C.GoBytes(unsafe.Pointer(raw), C.int(size)),
+			Stride: opts.Width * 4,
+			Rect:   image.Rect(0, 0, opts.Width, opts.Height),
+		}
+	}
+	return legend, nil
+}
+
+// Image composes all swatches into a single image. Swatches are stacked
+// vertically with padding pixel between and around them.
+func (l *Legend) Image(padding int) *image.NRGBA {
+	width, height := 0, padding
+	for _, item := range l.Items {
+		if dx := item.Image.Rect.Dx(); dx > width {
+			width = dx
+		}
+		height += item.Image.Rect.Dy() + padding
+	}
+	result := image.NewNRGBA(image.Rect(0, 0, width+2*padding, height))
+	y := padding
+	for _, item := range l.Items {
+		r := item.Image.Rect.Sub(item.Image.Rect.Min).Add(image.Pt(padding, y))
+		draw.Draw(result, r, item.Image, item.Image.Rect.Min, draw.Over)
+		y += item.Image.Rect.Dy() + padding
+	}
+	return result
+}
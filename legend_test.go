@@ -0,0 +1,56 @@
+package mapnik
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRenderLegend(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	legend, err := m.RenderLegend(LegendOpts{Width: 20, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// layerD is not active
+	if len(legend.Items) != 3 {
+		t.Fatal("unexpected legend items", legend.Items)
+	}
+	for i, layer := range []string{"layerA", "layerB", "layerC"} {
+		item := legend.Items[i]
+		assertEqual(t, layer, item.Layer)
+		assertEqual(t, "style"+layer[len(layer)-1:], item.Style)
+		assertEqual(t, "true", item.Filter)
+		assertEqual(t, image.Rect(0, 0, 20, 10), item.Image.Rect)
+	}
+
+	// swatches are transparent, without the map background
+	assertEqual(t, color.NRGBA{}, legend.Items[0].Image.NRGBAAt(0, 0))
+	c := legend.Items[0].Image.NRGBAAt(10, 5)
+	if c.R < 250 || c.G != 0 || c.B != 0 || c.A < 120 || c.A > 135 {
+		t.Error("unexpected swatch color", c)
+	}
+	c = legend.Items[1].Image.NRGBAAt(10, 5)
+	if c.R != 0 || c.G < 250 || c.B != 0 {
+		t.Error("unexpected swatch color", c)
+	}
+}
+
+func TestLegendImage(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+
+	legend, err := m.RenderLegend(LegendOpts{Width: 20, Height: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := legend.Image(2)
+	assertEqual(t, image.Rect(0, 0, 24, 3*10+4*2), img.Rect)
+	assertEqual(t, legend.Items[1].Image.NRGBAAt(10, 5), img.NRGBAAt(12, 2+10+2+5))
+}
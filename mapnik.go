@@ -13,7 +13,9 @@ import (
 	"image/draw"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"time"
 	"unsafe"
 )
 
@@ -120,30 +122,37 @@ func init() {
 	Version.String = fmt.Sprintf("%d.%d.%d", Version.Major, Version.Minor, Version.Patch)
 }
 
+// ErrMapFreed is returned by all methods of a Map after Free.
+var ErrMapFreed = errors.New("mapnik: map already freed")
+
 // Map base type
+//
+// Call Free to release the map. Maps that are not freed are released by
+// the garbage collector. Methods without an error return value do nothing
+// after Free, or return zero values.
 type Map struct {
 	m           *C.struct__mapnik_map_t
 	width       int
 	height      int
 	layerStatus []bool
+	// groups from SetLayerGroup
+	groups map[string][]string
 }
 
 // New initializes a new Map.
 func New() *Map {
-	return &Map{
-		m:      C.mapnik_map(C.uint(800), C.uint(600)),
-		width:  800,
-		height: 600,
-	}
+	return NewSized(800, 600)
 }
 
 // NewSized initializes a new Map with the given size.
 func NewSized(width, height int) *Map {
-	return &Map{
+	m := &Map{
 		m:      C.mapnik_map(C.uint(width), C.uint(height)),
 		width:  width,
 		height: height,
 	}
+	runtime.SetFinalizer(m, (*Map).Free)
+	return m
 }
 
 func (m *Map) lastError() error {
@@ -157,9 +166,38 @@ func (m *Map) lastError() error {
 // starting with '__OFF__' are disabled on load and the '__OFF__' prefix is removed
 // from the layer name.
 func (m *Map) Load(stylesheet string) error {
+	return m.LoadWithOpts(stylesheet, LoadOpts{})
+}
+
+// LoadOpts defines options for loading stylesheets.
+type LoadOpts struct {
+	// Strict fails on unknown elements and attributes, and on invalid
+	// values that Mapnik ignores otherwise.
+	Strict bool
+	// BasePath for relative paths of datasources and symbolizer files.
+	// Defaults to the base attribute of the Map element, or to the
+	// directory of the stylesheet.
+	BasePath string
+}
+
+// LoadWithOpts reads in a Mapnik map XML, like Load.
+func (m *Map) LoadWithOpts(stylesheet string, opts LoadOpts) error {
+	if m.m == nil {
+		return ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
 	cs := C.CString(stylesheet)
 	defer C.free(unsafe.Pointer(cs))
-	if C.mapnik_map_load(m.m, cs) != 0 {
+	strict := C.int(0)
+	if opts.Strict {
+		strict = 1
+	}
+	var basePath *C.char
+	if opts.BasePath != "" {
+		basePath = C.CString(opts.BasePath)
+		defer C.free(unsafe.Pointer(basePath))
+	}
+	if C.mapnik_map_load_opts(m.m, cs, strict, basePath) != 0 {
 		return m.lastError()
 	}
 
@@ -167,29 +205,69 @@ func (m *Map) Load(stylesheet string) error {
 	return nil
 }
 
+// Clone returns a copy of the map with all layers, styles and the current
+// size, extent and layer selection. Clone is faster than loading the same
+// stylesheet again, as the XML is not parsed and the datasources are shared
+// with the original map. Both maps need to be freed separately. The clone
+// of a freed map is also freed.
+func (m *Map) Clone() *Map {
+	c := &Map{
+		m:      C.mapnik_map_clone(m.m),
+		width:  m.width,
+		height: m.height,
+	}
+	runtime.KeepAlive(m)
+	if c.m != nil {
+		runtime.SetFinalizer(c, (*Map).Free)
+	}
+	if m.layerStatus != nil {
+		c.layerStatus = append([]bool(nil), m.layerStatus...)
+	}
+	for group, layers := range m.groups {
+		c.SetLayerGroup(group, layers...)
+	}
+	return c
+}
+
 // Resize changes the map size in pixel.
 // Sizes larger than 16k pixels are ignored by Mapnik. Use NewSized
 // to initialize larger maps.
 func (m *Map) Resize(width, height int) {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_resize(m.m, C.uint(width), C.uint(height))
 	m.width = width
 	m.height = height
 }
 
-// Free deallocates the map.
+// Free deallocates the map. Free can be called multiple times.
 func (m *Map) Free() {
+	if m.m == nil {
+		return
+	}
 	C.mapnik_map_free(m.m)
 	m.m = nil
+	runtime.SetFinalizer(m, nil)
 }
 
 // SRS returns the projection of the map.
 func (m *Map) SRS() string {
+	if m.m == nil {
+		return ""
+	}
+	defer runtime.KeepAlive(m)
 	return C.GoString(C.mapnik_map_get_srs(m.m))
 }
 
 // SetSRS sets the projection of the map as a Proj string ('epsg:4326', or
 // '+init=epsg:4326' if you are using Mapnik with Proj4).
 func (m *Map) SetSRS(srs string) {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	cs := C.CString(srs)
 	defer C.free(unsafe.Pointer(cs))
 	C.mapnik_map_set_srs(m.m, cs)
@@ -197,11 +275,19 @@ func (m *Map) SetSRS(srs string) {
 
 // ScaleDenominator returns the current scale denominator. Call after Resize and ZoomAll/ZoomTo.
 func (m *Map) ScaleDenominator() float64 {
+	if m.m == nil {
+		return 0
+	}
+	defer runtime.KeepAlive(m)
 	return float64(C.mapnik_map_get_scale_denominator(m.m))
 }
 
 // ZoomAll zooms to the maximum extent.
 func (m *Map) ZoomAll() error {
+	if m.m == nil {
+		return ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
 	if C.mapnik_map_zoom_all(m.m) != 0 {
 		return m.lastError()
 	}
@@ -210,18 +296,60 @@ func (m *Map) ZoomAll() error {
 
 // ZoomTo zooms to the given bounding box.
 func (m *Map) ZoomTo(minx, miny, maxx, maxy float64) {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	bbox := C.mapnik_bbox(C.double(minx), C.double(miny), C.double(maxx), C.double(maxy))
 	defer C.mapnik_bbox_free(bbox)
 	C.mapnik_map_zoom_to_box(m.m, bbox)
 }
 
+// Extent returns the current extent of the map as minx, miny, maxx, maxy in
+// the map SRS. The extent of ZoomTo is extended to the aspect ratio of the
+// map size.
+func (m *Map) Extent() [4]float64 {
+	if m.m == nil {
+		return [4]float64{}
+	}
+	defer runtime.KeepAlive(m)
+	var x0, y0, x1, y1 C.double
+	C.mapnik_map_current_extent(m.m, &x0, &y0, &x1, &y1)
+	return [4]float64{float64(x0), float64(y0), float64(x1), float64(y1)}
+}
+
+func (m *Map) georeference() (georeference, error) {
+	srs := m.SRS()
+	cs := C.CString(srs)
+	defer C.free(unsafe.Pointer(cs))
+	geographic := C.mapnik_srs_is_geographic(cs)
+	if geographic == -1 {
+		return georeference{}, fmt.Errorf("mapnik: invalid SRS %q", srs)
+	}
+	return georeference{
+		extent:     m.Extent(),
+		width:      m.width,
+		height:     m.height,
+		srs:        srs,
+		geographic: geographic == 1,
+	}, nil
+}
+
 func (m *Map) BackgroundColor() color.NRGBA {
+	if m.m == nil {
+		return color.NRGBA{}
+	}
+	defer runtime.KeepAlive(m)
 	c := color.NRGBA{}
 	C.mapnik_map_background(m.m, (*C.uint8_t)(&c.R), (*C.uint8_t)(&c.G), (*C.uint8_t)(&c.B), (*C.uint8_t)(&c.A))
 	return c
 }
 
 func (m *Map) SetBackgroundColor(c color.NRGBA) {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_set_background(m.m, C.uint8_t(c.R), C.uint8_t(c.G), C.uint8_t(c.B), C.uint8_t(c.A))
 }
 
@@ -272,6 +400,61 @@ func (m *Map) resetLayerStatus() {
 	m.layerStatus = nil
 }
 
+// Layer describes a single map layer.
+type Layer struct {
+	Name   string
+	SRS    string
+	Active bool
+	// Extent of the layer datasource in the layer SRS as minx, miny, maxx, maxy.
+	// Zero if the extent is unknown.
+	Extent [4]float64
+}
+
+// Layers returns all layers of the map.
+func (m *Map) Layers() []Layer {
+	if m.m == nil {
+		return nil
+	}
+	defer runtime.KeepAlive(m)
+	n := int(C.mapnik_map_layer_count(m.m))
+	layers := make([]Layer, n)
+	for i := 0; i < n; i++ {
+		l := &layers[i]
+		l.Name = C.GoString(C.mapnik_map_layer_name(m.m, C.size_t(i)))
+		l.SRS = C.GoString(C.mapnik_map_layer_srs(m.m, C.size_t(i)))
+		l.Active = C.mapnik_map_layer_is_active(m.m, C.size_t(i)) == 1
+		var x0, y0, x1, y1 C.double
+		if C.mapnik_map_layer_extent(m.m, C.size_t(i), &x0, &y0, &x1, &y1) == 0 {
+			l.Extent = [4]float64{float64(x0), float64(y0), float64(x1), float64(y1)}
+		}
+	}
+	return layers
+}
+
+func (m *Map) layerIndex(name string) (int, bool) {
+	n := int(C.mapnik_map_layer_count(m.m))
+	for i := 0; i < n; i++ {
+		if C.GoString(C.mapnik_map_layer_name(m.m, C.size_t(i))) == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Transform transforms the bbox (minx, miny, maxx, maxy) from the src to
+// the dst projection. See SetSRS for the format of the projections.
+func Transform(src, dst string, bbox [4]float64) ([4]float64, error) {
+	csrc := C.CString(src)
+	defer C.free(unsafe.Pointer(csrc))
+	cdst := C.CString(dst)
+	defer C.free(unsafe.Pointer(cdst))
+	x0, y0, x1, y1 := C.double(bbox[0]), C.double(bbox[1]), C.double(bbox[2]), C.double(bbox[3])
+	if C.mapnik_transform_bbox(csrc, cdst, &x0, &y0, &x1, &y1) != 0 {
+		return bbox, fmt.Errorf("mapnik: unable to transform bbox from %q to %q", src, dst)
+	}
+	return [4]float64{float64(x0), float64(y0), float64(x1), float64(y1)}, nil
+}
+
 // Status defines if a layer should be rendered or not.
 type Status int
 
@@ -297,6 +480,10 @@ func (f SelectorFunc) Select(layername string) Status {
 // SelectLayers enables/disables single layers. LayerSelector or SelectorFunc gets called for each layer.
 // Returns true if at least one layer was included (or set to default).
 func (m *Map) SelectLayers(selector LayerSelector) bool {
+	if m.m == nil {
+		return false
+	}
+	defer runtime.KeepAlive(m)
 	m.storeLayerStatus()
 	selected := false
 	n := C.mapnik_map_layer_count(m.m)
@@ -317,14 +504,26 @@ func (m *Map) SelectLayers(selector LayerSelector) bool {
 
 // ResetLayer resets all layers to the initial status.
 func (m *Map) ResetLayers() {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	m.resetLayerStatus()
 }
 
 func (m *Map) SetMaxExtent(minx, miny, maxx, maxy float64) {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_set_maximum_extent(m.m, C.double(minx), C.double(miny), C.double(maxx), C.double(maxy))
 }
 
 func (m *Map) ResetMaxExtent() {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_reset_maximum_extent(m.m)
 }
 
@@ -336,48 +535,201 @@ type RenderOpts struct {
 	ScaleFactor float64
 	// Format for the rendered image ('jpeg80', 'png256', etc. see: https://github.com/mapnik/mapnik/wiki/Image-IO)
 	Format string
+	// Encoding defines the format with typed options (PNGOptions, JPEGOptions, etc.).
+	// Takes precedence over Format.
+	Encoding EncodeOptions
+	// CollectStats is filled with statistics of the rendering if not nil.
+	// Supported by Render, RenderMulti, RenderImage and RenderPaletted.
+	CollectStats *RenderStats
+	// CollectLabels is filled with all labels placed by the renderer if
+	// not nil. Collecting labels slows down the rendering of maps with many
+	// labels. Supported by Render, RenderMulti, RenderImage and
+	// RenderPaletted.
+	CollectLabels *[]Label
+	// LabelDetector places labels without overlapping the labels of
+	// previous renderings with the same detector. The rendering adds all
+	// placed labels to the detector. Supported by Render, RenderMulti,
+	// RenderImage and RenderPaletted.
+	LabelDetector *LabelDetector
+	// Layers renders only the layers with these names, regardless of
+	// their active status. Unknown names are ignored. The active layers of
+	// the map are rendered if nil, no layers are rendered if Layers is an
+	// empty, non-nil slice. The layers are selected for the
+	// rendering only and the map is not changed, unlike with SelectLayers,
+	// so concurrent renderings of a map can select different layers.
+	Layers []string
+	// ExcludeLayers are not rendered, even if they are active or listed in
+	// Layers.
+	ExcludeLayers []string
+	// Georeference adds GeoTIFF tags with the extent and SRS of the map to
+	// TIFF images. RenderToFile writes a world file next to all other
+	// images (.pgw for PNG, .jgw for JPEG and .wld for others). Supported by
+	// Render and RenderToFile.
+	Georeference bool
+}
+
+// activeLayers returns the active status of each layer for opts.Layers and
+// opts.ExcludeLayers, or nil if the status of the map should be used.
+func (m *Map) activeLayers(opts RenderOpts) []C.int {
+	if opts.Layers == nil && len(opts.ExcludeLayers) == 0 {
+		return nil
+	}
+	include := make(map[string]bool, len(opts.Layers))
+	for _, l := range opts.Layers {
+		include[l] = true
+	}
+	exclude := make(map[string]bool, len(opts.ExcludeLayers))
+	for _, l := range opts.ExcludeLayers {
+		exclude[l] = true
+	}
+	n := int(C.mapnik_map_layer_count(m.m))
+	active := make([]C.int, n)
+	for i := 0; i < n; i++ {
+		name := C.GoString(C.mapnik_map_layer_name(m.m, C.size_t(i)))
+		if exclude[name] {
+			continue
+		}
+		if opts.Layers != nil {
+			if include[name] {
+				active[i] = 1
+			}
+		} else {
+			active[i] = C.mapnik_map_layer_is_active(m.m, C.size_t(i))
+		}
+	}
+	return active
 }
 
-// Render returns the map as an encoded image.
-func (m *Map) Render(opts RenderOpts) ([]byte, error) {
+// cIntPtr returns a pointer to the first element of s, or nil if s is empty.
+func cIntPtr(s []C.int) *C.int {
+	if len(s) == 0 {
+		return nil
+	}
+	return &s[0]
+}
+
+// renderImage renders the map into a new image. The caller needs to free
+// the image. format is only used for Metrics.
+func (m *Map) renderImage(opts RenderOpts, format string) (*C.struct__mapnik_image_t, error) {
+	var detector *C.struct__mapnik_label_detector_t
+	if opts.LabelDetector != nil {
+		if opts.LabelDetector.d == nil {
+			return nil, ErrLabelDetectorFreed
+		}
+		detector = opts.LabelDetector.d
+		defer runtime.KeepAlive(opts.LabelDetector)
+	}
+	start := time.Now()
 	scaleFactor := opts.ScaleFactor
 	if scaleFactor == 0.0 {
 		scaleFactor = 1.0
 	}
-	i := C.mapnik_map_render_to_image(m.m, C.double(opts.Scale), C.double(scaleFactor))
+	var stats *C.struct__mapnik_render_stats_t
+	if opts.CollectStats != nil {
+		stats = C.mapnik_render_stats()
+		defer C.mapnik_render_stats_free(stats)
+	}
+	var labels *C.struct__mapnik_labels_t
+	if opts.CollectLabels != nil {
+		labels = C.mapnik_labels()
+		defer C.mapnik_labels_free(labels)
+	}
+	active := m.activeLayers(opts)
+	i := C.mapnik_map_render_to_image_opts(m.m, C.double(opts.Scale), C.double(scaleFactor), stats, labels, detector, cIntPtr(active))
 	if i == nil {
+		observeError(ErrorRender)
 		return nil, m.lastError()
 	}
+	observeRender(format, start)
+	if stats != nil {
+		*opts.CollectStats = renderStats(stats)
+	}
+	if labels != nil {
+		*opts.CollectLabels = placedLabels(labels)
+	}
+	return i, nil
+}
+
+// Render returns the map as an encoded image.
+func (m *Map) Render(opts RenderOpts) ([]byte, error) {
+	if m.m == nil {
+		return nil, ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	enc, err := newEncoding(opts.Format, opts.Encoding)
+	if err != nil {
+		observeError(ErrorFormat)
+		return nil, err
+	}
+	i, err := m.renderImage(opts, enc.format)
+	if err != nil {
+		return nil, err
+	}
 	defer C.mapnik_image_free(i)
-	if opts.Format == "raw" {
+	if opts.Encoding == nil && opts.Format == "raw" {
 		size := 0
 		raw := C.mapnik_image_to_raw(i, (*C.size_t)(unsafe.Pointer(&size)))
 		return C.GoBytes(unsafe.Pointer(raw), C.int(size)), nil
 	}
-	var format *C.char
-	if opts.Format != "" {
-		format = C.CString(opts.Format)
-	} else {
-		format = C.CString("png256")
+	b, err := enc.encode(i)
+	if err != nil || !opts.Georeference || !enc.isTIFF() {
+		return b, err
+	}
+	g, err := m.georeference()
+	if err != nil {
+		return nil, err
+	}
+	return geoTIFF(b, g)
+}
+
+// RenderMulti renders the map once and returns the image encoded in each
+// of the formats, keyed by format. opts.Format and opts.Encoding are ignored.
+func (m *Map) RenderMulti(opts RenderOpts, formats []string) (map[string][]byte, error) {
+	if m.m == nil {
+		return nil, ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	encs := make([]encoding, len(formats))
+	for n, format := range formats {
+		enc, err := newEncoding(format, nil)
+		if err != nil {
+			observeError(ErrorFormat)
+			return nil, err
+		}
+		encs[n] = enc
 	}
-	b := C.mapnik_image_to_blob(i, format)
-	if b == nil {
-		return nil, errors.New("mapnik: " + C.GoString(C.mapnik_image_last_error(i)))
+	i, err := m.renderImage(opts, "multi")
+	if err != nil {
+		return nil, err
+	}
+	defer C.mapnik_image_free(i)
+
+	result := make(map[string][]byte, len(formats))
+	for n, format := range formats {
+		if format == "raw" {
+			size := 0
+			raw := C.mapnik_image_to_raw(i, (*C.size_t)(unsafe.Pointer(&size)))
+			result[format] = C.GoBytes(unsafe.Pointer(raw), C.int(size))
+			continue
+		}
+		b, err := encs[n].encode(i)
+		if err != nil {
+			return nil, err
+		}
+		result[format] = b
 	}
-	C.free(unsafe.Pointer(format))
-	defer C.mapnik_image_blob_free(b)
-	return C.GoBytes(unsafe.Pointer(b.ptr), C.int(b.len)), nil
+	return result, nil
 }
 
 // RenderImage returns the map as an unencoded image.Image.
 func (m *Map) RenderImage(opts RenderOpts) (*image.NRGBA, error) {
-	scaleFactor := opts.ScaleFactor
-	if scaleFactor == 0.0 {
-		scaleFactor = 1.0
+	if m.m == nil {
+		return nil, ErrMapFreed
 	}
-	i := C.mapnik_map_render_to_image(m.m, C.double(opts.Scale), C.double(scaleFactor))
-	if i == nil {
-		return nil, m.lastError()
+	defer runtime.KeepAlive(m)
+	i, err := m.renderImage(opts, "image")
+	if err != nil {
+		return nil, err
 	}
 	defer C.mapnik_image_free(i)
 	size := 0
@@ -393,27 +745,74 @@ func (m *Map) RenderImage(opts RenderOpts) (*image.NRGBA, error) {
 
 // RenderToFile writes the map as an encoded image to the file system.
 func (m *Map) RenderToFile(opts RenderOpts, path string) error {
+	if m.m == nil {
+		return ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	enc, err := newEncoding(opts.Format, opts.Encoding)
+	if err != nil {
+		observeError(ErrorFormat)
+		return err
+	}
+	var worldFile []byte
+	if opts.Georeference && !enc.isTIFF() {
+		g, err := m.georeference()
+		if err != nil {
+			return err
+		}
+		worldFile = g.worldFile()
+	}
+	if err := m.renderToFile(opts, enc, path); err != nil {
+		return err
+	}
+	// the world file is only written for successfully written images
+	if worldFile != nil {
+		if err := os.WriteFile(worldFilePath(path), worldFile, 0644); err != nil {
+			observeError(ErrorWrite)
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Map) renderToFile(opts RenderOpts, enc encoding, path string) error {
+	if enc.hasPalette() || (opts.Georeference && enc.isTIFF()) {
+		// palettes are only supported by our encoder, GeoTIFF tags are
+		// added to the encoded image
+		b, err := m.Render(opts)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, b, 0644); err != nil {
+			observeError(ErrorWrite)
+			return err
+		}
+		return nil
+	}
+	start := time.Now()
 	scaleFactor := opts.ScaleFactor
 	if scaleFactor == 0.0 {
 		scaleFactor = 1.0
 	}
 	cs := C.CString(path)
 	defer C.free(unsafe.Pointer(cs))
-	var format *C.char
-	if opts.Format != "" {
-		format = C.CString(opts.Format)
-	} else {
-		format = C.CString("png256")
-	}
+	format := C.CString(enc.format)
 	defer C.free(unsafe.Pointer(format))
-	if C.mapnik_map_render_to_file(m.m, cs, C.double(opts.Scale), C.double(scaleFactor), format) != 0 {
+	active := m.activeLayers(opts)
+	if C.mapnik_map_render_to_file_opts(m.m, cs, C.double(opts.Scale), C.double(scaleFactor), format, cIntPtr(active)) != 0 {
+		observeError(ErrorRender)
 		return m.lastError()
 	}
+	observeRender(enc.format, start)
 	return nil
 }
 
 // SetBufferSize sets the pixel buffer at the map image edges where Mapnik should not render any labels.
 func (m *Map) SetBufferSize(s int) {
+	if m.m == nil {
+		return
+	}
+	defer runtime.KeepAlive(m)
 	C.mapnik_map_set_buffer_size(m.m, C.int(s))
 }
 
@@ -427,21 +826,21 @@ func Encode(img image.Image, format string) ([]byte, error) {
 		C.int(tmp.Bounds().Dy()),
 	)
 	defer C.mapnik_image_free(i)
-
-	cformat := C.CString(format)
-	b := C.mapnik_image_to_blob(i, cformat)
-	if b == nil {
-		return nil, errors.New("mapnik: " + C.GoString(C.mapnik_image_last_error(i)))
-	}
-	C.free(unsafe.Pointer(cformat))
-	defer C.mapnik_image_blob_free(b)
-	return C.GoBytes(unsafe.Pointer(b.ptr), C.int(b.len)), nil
+	return encoding{format: format}.encode(i)
 }
 
 func toNRGBA(src image.Image) *image.NRGBA {
 	switch src := src.(type) {
 	case *image.NRGBA:
-		return src
+		if src.Stride == src.Rect.Dx()*4 {
+			return src
+		}
+		// copy sub-images, Mapnik expects contiguous rows
+		result := image.NewNRGBA(image.Rect(0, 0, src.Rect.Dx(), src.Rect.Dy()))
+		for y := 0; y < src.Rect.Dy(); y++ {
+			copy(result.Pix[y*result.Stride:(y+1)*result.Stride], src.Pix[y*src.Stride:])
+		}
+		return result
 	case *image.RGBA:
 		result := image.NewNRGBA(src.Bounds())
 		drawRGBAOver(result, result.Bounds(), src, image.Point{})
@@ -7,10 +7,29 @@
 #include <mapnik/layer.hpp>
 #include <mapnik/color.hpp>
 #include <mapnik/image_util.hpp>
+#include <mapnik/image_reader.hpp>
+#include <mapnik/palette.hpp>
 #include <mapnik/agg_renderer.hpp>
 #include <mapnik/load_map.hpp>
 #include <mapnik/datasource_cache.hpp>
 #include <mapnik/font_engine_freetype.hpp>
+#include <mapnik/projection.hpp>
+#include <mapnik/proj_transform.hpp>
+#include <mapnik/feature.hpp>
+#include <mapnik/feature_kv_iterator.hpp>
+#include <mapnik/featureset.hpp>
+#include <mapnik/feature_factory.hpp>
+#include <mapnik/feature_type_style.hpp>
+#include <mapnik/rule.hpp>
+#include <mapnik/expression_string.hpp>
+#include <mapnik/memory_datasource.hpp>
+#include <mapnik/datasource.hpp>
+#include <mapnik/query.hpp>
+#include <mapnik/expression_evaluator.hpp>
+#include <mapnik/label_collision_detector.hpp>
+#include <mapnik/raster_colorizer.hpp>
+#include <mapnik/image_compositing.hpp>
+#include <mapnik/symbolizer.hpp>
 
 
 #if MAPNIK_VERSION < 300000
@@ -20,6 +39,9 @@
 #include "mapnik_c_api.h"
 
 #include <stdlib.h>
+#include <algorithm>
+#include <chrono>
+#include <set>
 
 #ifdef __cplusplus
 extern "C"
@@ -59,6 +81,40 @@ int mapnik_register_font(const char* path) {
     }
 }
 
+char * mapnik_font_face_names() {
+    // Returns all registered font faces separated by newlines. The caller
+    // needs to free the result.
+    std::string names;
+    for (std::string const& name : mapnik::freetype_engine::face_names()) {
+        if (!names.empty()) {
+            names += "\n";
+        }
+        names += name;
+    }
+    char * result = (char *)malloc(names.size() + 1);
+    memcpy(result, names.c_str(), names.size() + 1);
+    return result;
+}
+
+int mapnik_srs_is_valid(const char * srs) {
+    try {
+        mapnik::projection prj(srs);
+    } catch (std::exception const& ex) {
+        return 0;
+    }
+    return 1;
+}
+
+int mapnik_srs_is_geographic(const char * srs) {
+    // Returns -1 for invalid SRS.
+    try {
+        mapnik::projection prj(srs);
+        return prj.is_geographic() ? 1 : 0;
+    } catch (std::exception const& ex) {
+        return -1;
+    }
+}
+
 const char *mapnik_register_last_error() {
     if (register_err) {
         return register_err->c_str();
@@ -97,6 +153,18 @@ mapnik_map_t * mapnik_map(unsigned width, unsigned height) {
     return map;
 }
 
+mapnik_map_t * mapnik_map_clone(mapnik_map_t * m) {
+    // Copies the map with all layers and styles. Datasources are shared
+    // between both maps.
+    if (!m || !m->m) {
+        return NULL;
+    }
+    mapnik_map_t * map = new mapnik_map_t;
+    map->m = new mapnik::Map(*m->m);
+    map->err = NULL;
+    return map;
+}
+
 void mapnik_map_free(mapnik_map_t * m) {
     if (m)  {
         if (m->m) {
@@ -139,10 +207,16 @@ double mapnik_map_get_scale_denominator(mapnik_map_t * m) {
 }
 
 int mapnik_map_load(mapnik_map_t * m, const char* stylesheet) {
+    return mapnik_map_load_opts(m, stylesheet, 0, NULL);
+}
+
+int mapnik_map_load_opts(mapnik_map_t * m, const char* stylesheet, int strict, const char* base_path) {
+    // Loads relative paths against base_path instead of the stylesheet
+    // directory if base_path is not NULL or empty.
     mapnik_map_reset_last_error(m);
     if (m && m->m) {
         try {
-            mapnik::load_map(*m->m, stylesheet);
+            mapnik::load_map(*m->m, stylesheet, strict != 0, base_path ? base_path : "");
         } catch (std::exception const& ex) {
             m->err = new std::string(ex.what());
             return -1;
@@ -217,6 +291,16 @@ void mapnik_bbox_free(mapnik_bbox_t * b) {
     }
 }
 
+void mapnik_map_current_extent(mapnik_map_t * m, double *x0, double *y0, double *x1, double *y1) {
+    if (m && m->m) {
+        mapnik::box2d<double> const& extent = m->m->get_current_extent();
+        *x0 = extent.minx();
+        *y0 = extent.miny();
+        *x1 = extent.maxx();
+        *y1 = extent.maxy();
+    }
+}
+
 void mapnik_map_zoom_to_box(mapnik_map_t * m, mapnik_bbox_t * b) {
     if (m && m->m && b) {
         m->m->zoom_to_box(b->b);
@@ -254,16 +338,439 @@ const char *mapnik_image_last_error(mapnik_image_t *i) {
     return NULL;
 }
 
+struct _mapnik_render_stats_t {
+    struct layer {
+        std::string name;
+        double seconds;
+        size_t fetched;
+        size_t rendered;
+        bool queried;
+        bool visible;
+    };
+    double seconds;
+    std::vector<layer> layers;
+};
+
+typedef std::chrono::steady_clock stats_clock;
+
+static double stats_seconds(stats_clock::time_point start, stats_clock::time_point end) {
+    return std::chrono::duration<double>(end - start).count();
+}
+
+struct _mapnik_labels_t {
+    struct label {
+        std::string layer;
+        int64_t feature_id;
+        double x0, y0, x1, y1;
+        std::string text;
+    };
+    std::vector<label> labels;
+};
+
+struct _mapnik_label_detector_t {
+    std::shared_ptr<mapnik::label_collision_detector4> d;
+};
+
+class label_recorder {
+    // Records all new labels of the collision detector. The detector does not
+    // know the origin of the labels, so the recorder needs to be updated after
+    // each feature to attribute new labels to the feature.
+    //
+    // Labels are never removed during rendering and the detector returns the
+    // labels of each quad tree node in insertion order, so the labels of the
+    // last update are always in the same order, with the new labels in
+    // between. New labels are found by comparing both lists in one pass, only
+    // if the number of labels changed.
+public:
+    label_recorder(mapnik::label_collision_detector4 & detector, mapnik_labels_t * labels)
+        : detector_(detector), labels_(labels) {
+        // labels that are already in the detector are not recorded
+        for (auto it = detector_.begin(), end = detector_.end(); it != end; ++it) {
+            known_.emplace_back(*it);
+        }
+    }
+
+    void update(std::string const& layer, int64_t feature_id) {
+        // begin() queries the tree and needs to be called before end()
+        auto it = detector_.begin();
+        auto end = detector_.end();
+        if (static_cast<size_t>(std::distance(it, end)) == known_.size()) {
+            return;
+        }
+        std::vector<known_label> current;
+        current.reserve(std::distance(it, end));
+        size_t n = 0;
+        for (; it != end; ++it) {
+            mapnik::label_collision_detector4::label const& l = *it;
+            current.emplace_back(l);
+            if (n < known_.size() && known_[n] == current.back()) {
+                n++;
+                continue;
+            }
+            std::string text;
+            l.text.toUTF8String(text);
+            mapnik::box2d<double> const& b = l.box;
+            _mapnik_labels_t::label label = {layer, feature_id, b.minx(), b.miny(), b.maxx(), b.maxy(), text};
+            labels_->labels.push_back(label);
+        }
+        known_.swap(current);
+    }
+
+private:
+    struct known_label {
+        explicit known_label(mapnik::label_collision_detector4::label const& l)
+            : box(l.box), text_hash(l.text.hashCode()) {}
+
+        bool operator==(known_label const& other) const {
+            return box == other.box && text_hash == other.text_hash;
+        }
+
+        mapnik::box2d<double> box;
+        int32_t text_hash;
+    };
+
+    mapnik::label_collision_detector4 & detector_;
+    mapnik_labels_t * labels_;
+    std::vector<known_label> known_;
+};
+
+class stats_featureset : public mapnik::Featureset {
+    // Counts fetched features and features that match a rule. The time between
+    // the first and the last next() includes the rendering of the features.
+public:
+    stats_featureset(mapnik::featureset_ptr fs, std::vector<mapnik::rule const*> const& rules,
+                     mapnik::attributes const& vars, _mapnik_render_stats_t::layer * stats,
+                     label_recorder * labels)
+        : fs_(fs), rules_(rules), vars_(vars), stats_(stats), labels_(labels), started_(false), feature_id_(0) {
+        if (labels_ && !places_labels(rules_)) {
+            labels_ = NULL;
+        }
+    }
+
+    ~stats_featureset() {
+        if (started_) {
+            stats_->seconds += stats_seconds(first_, last_);
+        }
+        if (labels_) {
+            labels_->update(stats_->name, 0);
+        }
+    }
+
+    mapnik::feature_ptr next() {
+        if (!started_) {
+            started_ = true;
+            first_ = stats_clock::now();
+        }
+        if (labels_) {
+            // the previous feature is rendered before the next is requested
+            labels_->update(stats_->name, feature_id_);
+        }
+        mapnik::feature_ptr feature = fs_->next();
+        feature_id_ = 0;
+        if (feature) {
+            feature_id_ = feature->id();
+            stats_->fetched++;
+            if (matches(*feature)) {
+                stats_->rendered++;
+            }
+        }
+        last_ = stats_clock::now();
+        return feature;
+    }
+
+private:
+    static bool places_labels(std::vector<mapnik::rule const*> const& rules) {
+        // Only these symbolizers add labels to the collision detector.
+        for (mapnik::rule const* r : rules) {
+            for (mapnik::symbolizer const& sym : r->get_symbolizers()) {
+                if (sym.is<mapnik::text_symbolizer>() || sym.is<mapnik::shield_symbolizer>() ||
+                        sym.is<mapnik::point_symbolizer>() || sym.is<mapnik::markers_symbolizer>() ||
+                        sym.is<mapnik::group_symbolizer>()) {
+                    return true;
+                }
+            }
+        }
+        return false;
+    }
+
+    bool matches(mapnik::feature_impl const& feature) const {
+        bool has_else = false;
+        for (mapnik::rule const* r : rules_) {
+            if (r->has_else_filter()) {
+                has_else = true;
+                continue;
+            }
+            if (r->has_also_filter()) {
+                continue;
+            }
+            mapnik::value_type result = mapnik::util::apply_visitor(
+                                            mapnik::evaluate<mapnik::feature_impl, mapnik::value_type, mapnik::attributes>(feature, vars_),
+                                            *r->get_filter());
+            if (result.to_bool()) {
+                return true;
+            }
+        }
+        return has_else;
+    }
+
+    mapnik::featureset_ptr fs_;
+    std::vector<mapnik::rule const*> rules_;
+    mapnik::attributes vars_;
+    _mapnik_render_stats_t::layer * stats_;
+    label_recorder * labels_;
+    bool started_;
+    int64_t feature_id_;
+    stats_clock::time_point first_;
+    stats_clock::time_point last_;
+};
+
+class stats_datasource : public mapnik::datasource {
+    // Forwards all calls to the layer datasource and wraps the featuresets.
+public:
+    stats_datasource(mapnik::datasource_ptr ds, std::vector<mapnik::rule const*> rules, _mapnik_render_stats_t::layer * stats,
+                     label_recorder * labels)
+        : mapnik::datasource(ds->params()), ds_(ds), rules_(rules), stats_(stats), labels_(labels) {}
+
+    datasource_t type() const {
+        return ds_->type();
+    }
+
+    mapnik::processor_context_ptr get_context(mapnik::feature_style_context_map & ctx) const {
+        return ds_->get_context(ctx);
+    }
+
+    mapnik::featureset_ptr features_with_context(mapnik::query const& q, mapnik::processor_context_ptr ctx) const {
+        stats_clock::time_point start = stats_clock::now();
+        mapnik::featureset_ptr fs = ds_->features_with_context(q, ctx);
+        return wrap(fs, q, start);
+    }
+
+    mapnik::featureset_ptr features(mapnik::query const& q) const {
+        stats_clock::time_point start = stats_clock::now();
+        mapnik::featureset_ptr fs = ds_->features(q);
+        return wrap(fs, q, start);
+    }
+
+    mapnik::featureset_ptr features_at_point(mapnik::coord2d const& pt, double tol = 0) const {
+        return ds_->features_at_point(pt, tol);
+    }
+
+    mapnik::box2d<double> envelope() const {
+        return ds_->envelope();
+    }
+
+#if MAPNIK_VERSION > 400000
+    std::optional<mapnik::datasource_geometry_t> get_geometry_type() const {
+#else
+    boost::optional<mapnik::datasource_geometry_t> get_geometry_type() const {
+#endif
+        return ds_->get_geometry_type();
+    }
+
+    mapnik::layer_descriptor get_descriptor() const {
+        return ds_->get_descriptor();
+    }
+
+private:
+    mapnik::featureset_ptr wrap(mapnik::featureset_ptr fs, mapnik::query const& q, stats_clock::time_point start) const {
+        stats_->queried = true;
+        stats_->seconds += stats_seconds(start, stats_clock::now());
+        if (!fs) {
+            return fs;
+        }
+        return std::make_shared<stats_featureset>(fs, rules_, q.variables(), stats_, labels_);
+    }
+
+    mapnik::datasource_ptr ds_;
+    std::vector<mapnik::rule const*> rules_;
+    _mapnik_render_stats_t::layer * stats_;
+    label_recorder * labels_;
+};
+
+static std::vector<mapnik::layer> render_layers(mapnik::Map const& m, double scale_denom, const int * active,
+                                                mapnik_render_stats_t * stats, label_recorder * labels) {
+    // Returns copies of the layers to render: the layers marked in active (one
+    // entry for each layer) or the active layers of the map if active is NULL.
+    // Replaces the datasources of the copies with stats_datasources if stats
+    // is not NULL. Labels are only recorded if labels is not NULL. The map is
+    // not changed.
+    std::vector<mapnik::layer> layers;
+    for (size_t idx = 0; idx < m.layer_count(); idx++) {
+        mapnik::layer layer(m.layers()[idx]);
+        if (active) {
+            layer.set_active(active[idx] != 0);
+        }
+        if (layer.active()) {
+            layers.push_back(std::move(layer));
+        }
+    }
+    if (!stats) {
+        return layers;
+    }
+    stats->seconds = 0;
+    stats->layers.clear();
+    // stats_datasources keep pointers to the layer stats
+    stats->layers.reserve(layers.size());
+    for (mapnik::layer & layer : layers) {
+        mapnik::datasource_ptr ds = layer.datasource();
+        if (!ds) {
+            continue;
+        }
+        std::vector<mapnik::rule const*> rules;
+        for (std::string const& style_name : layer.styles()) {
+            auto style = m.styles().find(style_name);
+            if (style == m.styles().end()) {
+                continue;
+            }
+            for (mapnik::rule const& r : style->second.get_rules()) {
+                if (r.active(scale_denom)) {
+                    rules.push_back(&r);
+                }
+            }
+        }
+        _mapnik_render_stats_t::layer l = {layer.name(), 0, 0, 0, false, layer.visible(scale_denom) && !rules.empty()};
+        stats->layers.push_back(l);
+        layer.set_datasource(std::make_shared<stats_datasource>(ds, rules, &stats->layers.back(), labels));
+    }
+    return layers;
+}
+
+static double render_scale_denominator(mapnik::Map const& m, double scale, double scale_factor) {
+    // Same as feature_style_processor::apply.
+    if (scale <= 0.0) {
+        scale = m.scale_denominator();
+    }
+    return scale * scale_factor;
+}
+
+template <typename Renderer>
+static void render_map_layers(Renderer & ren, mapnik::Map const& m, std::vector<mapnik::layer> const& layers, double scale_denom) {
+    // Renders the layers like feature_style_processor::apply renders the
+    // layers of the map, see render_layer of python-mapnik.
+    mapnik::projection proj(m.srs(), true);
+    ren.start_map_processing(m);
+    for (mapnik::layer const& layer : layers) {
+        if (layer.visible(scale_denom)) {
+            std::set<std::string> names;
+            ren.apply_to_layer(layer, ren, proj, m.scale(), scale_denom, m.width(), m.height(),
+                               m.get_current_extent(), m.buffer_size(), names);
+        }
+    }
+    ren.end_map_processing(m);
+}
+
+mapnik_render_stats_t * mapnik_render_stats() {
+    mapnik_render_stats_t * stats = new mapnik_render_stats_t;
+    stats->seconds = 0;
+    return stats;
+}
+
+void mapnik_render_stats_free(mapnik_render_stats_t * stats) {
+    if (stats) {
+        delete stats;
+    }
+}
+
+double mapnik_render_stats_seconds(mapnik_render_stats_t * stats) {
+    if (stats) {
+        return stats->seconds;
+    }
+    return 0;
+}
+
+int mapnik_render_stats_layer_count(mapnik_render_stats_t * stats) {
+    if (stats) {
+        return stats->layers.size();
+    }
+    return 0;
+}
+
+const char * mapnik_render_stats_layer_name(mapnik_render_stats_t * stats, size_t idx) {
+    if (stats && idx < stats->layers.size()) {
+        return stats->layers[idx].name.c_str();
+    }
+    return NULL;
+}
+
+double mapnik_render_stats_layer_seconds(mapnik_render_stats_t * stats, size_t idx) {
+    if (stats && idx < stats->layers.size()) {
+        return stats->layers[idx].seconds;
+    }
+    return 0;
+}
+
+size_t mapnik_render_stats_layer_fetched(mapnik_render_stats_t * stats, size_t idx) {
+    if (stats && idx < stats->layers.size()) {
+        return stats->layers[idx].fetched;
+    }
+    return 0;
+}
+
+size_t mapnik_render_stats_layer_rendered(mapnik_render_stats_t * stats, size_t idx) {
+    if (stats && idx < stats->layers.size()) {
+        return stats->layers[idx].rendered;
+    }
+    return 0;
+}
+
+int mapnik_render_stats_layer_status(mapnik_render_stats_t * stats, size_t idx) {
+    if (stats && idx < stats->layers.size()) {
+        _mapnik_render_stats_t::layer const& l = stats->layers[idx];
+        if (l.queried) {
+            return MAPNIK_LAYER_RENDERED;
+        }
+        if (!l.visible) {
+            return MAPNIK_LAYER_SKIPPED_SCALE;
+        }
+        return MAPNIK_LAYER_SKIPPED_EXTENT;
+    }
+    return MAPNIK_LAYER_RENDERED;
+}
+
 mapnik_image_t * mapnik_map_render_to_image(mapnik_map_t * m, double scale, double scale_factor) {
+    return mapnik_map_render_to_image_opts(m, scale, scale_factor, NULL, NULL, NULL, NULL);
+}
+
+mapnik_image_t * mapnik_map_render_to_image_opts(mapnik_map_t * m, double scale, double scale_factor, mapnik_render_stats_t * stats,
+        mapnik_labels_t * labels, mapnik_label_detector_t * d, const int * active) {
+    // Collects statistics of the rendering into stats and the placed labels
+    // into labels if not NULL. Places labels with the detector d if not NULL.
+    // Renders the layers marked in active (one entry for each layer) instead
+    // of the active layers of the map if not NULL.
     mapnik_map_reset_last_error(m);
     mapnik_rgba_image * im = new mapnik_rgba_image(m->m->width(), m->m->height());
     if (m && m->m) {
         try {
-            mapnik::agg_renderer<mapnik_rgba_image> ren(*m->m, *im, scale_factor);
-            if (scale > 0.0) {
-                ren.apply(scale);
+            std::shared_ptr<mapnik::label_collision_detector4> detector;
+            if (d) {
+                detector = d->d;
             } else {
-                ren.apply();
+                int buffer_size = m->m->buffer_size();
+                // same extent as the default detector of the renderer
+                detector = std::make_shared<mapnik::label_collision_detector4>(
+                               mapnik::box2d<double>(-buffer_size, -buffer_size, m->m->width() + buffer_size, m->m->height() + buffer_size));
+            }
+            std::unique_ptr<label_recorder> recorder;
+            if (labels) {
+                recorder.reset(new label_recorder(*detector, labels));
+            }
+            // labels are recorded with the stats_datasources
+            mapnik_render_stats_t label_stats;
+            if (labels && !stats) {
+                stats = &label_stats;
+            }
+            double scale_denom = render_scale_denominator(*m->m, scale, scale_factor);
+            std::vector<mapnik::layer> layers = render_layers(*m->m, scale_denom, active, stats, recorder.get());
+            stats_clock::time_point start = stats_clock::now();
+            mapnik::agg_renderer<mapnik_rgba_image> ren(*m->m, *im, detector, scale_factor);
+            render_map_layers(ren, *m->m, layers, scale_denom);
+            if (stats) {
+                stats->seconds = stats_seconds(start, stats_clock::now());
+            }
+            if (recorder) {
+                // labels of cached features are rendered after the featuresets
+                // are released
+                recorder->update(std::string(), 0);
             }
         } catch (std::exception const& ex) {
             delete im;
@@ -277,17 +784,94 @@ mapnik_image_t * mapnik_map_render_to_image(mapnik_map_t * m, double scale, doub
     return i;
 }
 
+mapnik_label_detector_t * mapnik_label_detector(double x0, double y0, double x1, double y1) {
+    mapnik_label_detector_t * d = new mapnik_label_detector_t;
+    d->d = std::make_shared<mapnik::label_collision_detector4>(mapnik::box2d<double>(x0, y0, x1, y1));
+    return d;
+}
+
+void mapnik_label_detector_free(mapnik_label_detector_t * d) {
+    if (d) {
+        delete d;
+    }
+}
+
+void mapnik_label_detector_insert(mapnik_label_detector_t * d, double x0, double y0, double x1, double y1) {
+    if (d) {
+        d->d->insert(mapnik::box2d<double>(x0, y0, x1, y1));
+    }
+}
+
+void mapnik_label_detector_clear(mapnik_label_detector_t * d) {
+    if (d) {
+        d->d->clear();
+    }
+}
+
+mapnik_labels_t * mapnik_labels() {
+    return new mapnik_labels_t;
+}
+
+void mapnik_labels_free(mapnik_labels_t * labels) {
+    if (labels) {
+        delete labels;
+    }
+}
+
+size_t mapnik_labels_count(mapnik_labels_t * labels) {
+    if (labels) {
+        return labels->labels.size();
+    }
+    return 0;
+}
+
+const char * mapnik_labels_layer(mapnik_labels_t * labels, size_t idx) {
+    if (labels && idx < labels->labels.size()) {
+        return labels->labels[idx].layer.c_str();
+    }
+    return NULL;
+}
+
+int64_t mapnik_labels_feature_id(mapnik_labels_t * labels, size_t idx) {
+    if (labels && idx < labels->labels.size()) {
+        return labels->labels[idx].feature_id;
+    }
+    return 0;
+}
+
+void mapnik_labels_box(mapnik_labels_t * labels, size_t idx, double * x0, double * y0, double * x1, double * y1) {
+    if (labels && idx < labels->labels.size()) {
+        _mapnik_labels_t::label const& l = labels->labels[idx];
+        *x0 = l.x0;
+        *y0 = l.y0;
+        *x1 = l.x1;
+        *y1 = l.y1;
+    }
+}
+
+const char * mapnik_labels_text(mapnik_labels_t * labels, size_t idx) {
+    if (labels && idx < labels->labels.size()) {
+        return labels->labels[idx].text.c_str();
+    }
+    return NULL;
+}
+
 int mapnik_map_render_to_file(mapnik_map_t * m, const char* filepath, double scale, double scale_factor, const char *format) {
+    return mapnik_map_render_to_file_opts(m, filepath, scale, scale_factor, format, NULL);
+}
+
+int mapnik_map_render_to_file_opts(mapnik_map_t * m, const char* filepath, double scale, double scale_factor, const char *format,
+                                   const int * active) {
+    // Renders the layers marked in active instead of the active layers of
+    // the map if not NULL.
     mapnik_map_reset_last_error(m);
     if (m && m->m) {
         try {
+            double scale_denom = render_scale_denominator(*m->m, scale, scale_factor);
+            std::vector<mapnik::layer> layers = render_layers(*m->m, scale_denom, active, NULL, NULL);
             mapnik_rgba_image buf(m->m->width(), m->m->height());
             mapnik::agg_renderer<mapnik_rgba_image> ren(*m->m, buf, scale_factor);
-            if (scale > 0.0) {
-                ren.apply(scale);
-            } else {
-                ren.apply();
-            }
+            render_map_layers(ren, *m->m, layers, scale_denom);
             mapnik::save_to_file(buf, filepath, format);
         } catch (std::exception const& ex) {
             m->err = new std::string(ex.what());
@@ -327,6 +911,115 @@ mapnik_image_blob_t * mapnik_image_to_blob(mapnik_image_t * i, const char *forma
     return blob;
 }
 
+struct _mapnik_palette_t {
+    mapnik::rgba_palette * p;
+    std::string * err;
+};
+
+mapnik_palette_t * mapnik_palette(const char * data, size_t len, int type) {
+    // Always returns a palette. Check for errors with mapnik_palette_last_error.
+    mapnik_palette_t * pal = new mapnik_palette_t;
+    pal->p = NULL;
+    pal->err = NULL;
+    mapnik::rgba_palette::palette_type t;
+    switch (type) {
+    case MAPNIK_PALETTE_RGB:
+        t = mapnik::rgba_palette::PALETTE_RGB;
+        break;
+    case MAPNIK_PALETTE_ACT:
+        t = mapnik::rgba_palette::PALETTE_ACT;
+        break;
+    default:
+        t = mapnik::rgba_palette::PALETTE_RGBA;
+        break;
+    }
+    try {
+        pal->p = new mapnik::rgba_palette(std::string(data, len), t);
+        if (!pal->p->valid()) {
+            delete pal->p;
+            pal->p = NULL;
+            pal->err = new std::string("invalid palette");
+        }
+    } catch (std::exception const& ex) {
+        pal->err = new std::string(ex.what());
+    }
+    return pal;
+}
+
+void mapnik_palette_free(mapnik_palette_t * p) {
+    if (p) {
+        if (p->p) {
+            delete p->p;
+        }
+        if (p->err) {
+            delete p->err;
+        }
+        delete p;
+    }
+}
+
+const char * mapnik_palette_last_error(mapnik_palette_t * p) {
+    if (p && p->err) {
+        return p->err->c_str();
+    }
+    return NULL;
+}
+
+int mapnik_palette_colors(mapnik_palette_t * p, uint8_t * rgba) {
+    // Writes up to 256 RGBA colors in the order used by mapnik_image_quantize.
+    if (!p || !p->p) {
+        return 0;
+    }
+    std::vector<mapnik::rgb> const& colors = p->p->palette();
+    std::vector<unsigned> const& alpha = p->p->alphaTable();
+    size_t n = std::min(colors.size(), (size_t)256);
+    for (size_t idx = 0; idx < n; idx++) {
+        rgba[idx * 4 + 0] = colors[idx].r;
+        rgba[idx * 4 + 1] = colors[idx].g;
+        rgba[idx * 4 + 2] = colors[idx].b;
+        rgba[idx * 4 + 3] = idx < alpha.size() ? alpha[idx] : 255;
+    }
+    return n;
+}
+
+int mapnik_image_quantize(mapnik_image_t * i, mapnik_palette_t * p, uint8_t * indices) {
+    // Writes the palette index of each pixel to indices (width * height bytes).
+    if (!i || !i->i || !p || !p->p) {
+        return -1;
+    }
+    size_t width = i->i->width();
+    for (size_t y = 0; y < i->i->height(); y++) {
+        mapnik_rgba_image::pixel_type const * row = i->i->get_row(y);
+        for (size_t x = 0; x < width; x++) {
+            indices[y * width + x] = p->p->quantize(row[x]);
+        }
+    }
+    return 0;
+}
+
+mapnik_image_blob_t * mapnik_image_to_blob_with_palette(mapnik_image_t * i, const char *format, mapnik_palette_t * p) {
+    mapnik_image_reset_last_error(i);
+    if (!p || !p->p) {
+        return mapnik_image_to_blob(i, format);
+    }
+    mapnik_image_blob_t * blob = new mapnik_image_blob_t;
+    blob->ptr = NULL;
+    blob->len = 0;
+    if (i && i->i) {
+        try {
+            std::string s = save_to_string(*(i->i), format, *(p->p));
+            blob->len = s.length();
+            blob->ptr = new char[blob->len];
+            memcpy(blob->ptr, s.c_str(), blob->len);
+        } catch (std::exception const& ex) {
+            i->err = new std::string(ex.what());
+            delete blob;
+            return NULL;
+        }
+    }
+    return blob;
+}
+
 const uint8_t * mapnik_image_to_raw(mapnik_image_t * i, size_t * size) {
     if (i && i->i) {
         *size = i->i->width() * i->i->height() * 4;
@@ -343,6 +1036,102 @@ mapnik_image_t * mapnik_image_from_raw(const uint8_t * raw, int width, int heigh
     return img;
 }
 
+mapnik_image_t * mapnik_image_blank(int width, int height) {
+    // Returns a transparent image.
+    mapnik_image_t * img = new mapnik_image_t;
+    img->i = new mapnik_rgba_image(width, height);
+    img->err = NULL;
+    return img;
+}
+
+int mapnik_comp_op_is_valid(const char * comp_op) {
+    return mapnik::comp_op_from_string(comp_op) ? 1 : 0;
+}
+
+int mapnik_image_composite(mapnik_image_t * dst, mapnik_image_t * src, const char * comp_op, double opacity) {
+    // Composites src onto dst. Both images are premultiplied, call
+    // mapnik_image_demultiply on dst after the last composite.
+    if (dst && dst->i && src && src->i) {
+        if (dst->err) {
+            delete dst->err;
+            dst->err = NULL;
+        }
+        try {
+            auto mode = mapnik::comp_op_from_string(comp_op);
+            if (!mode) {
+                dst->err = new std::string("invalid comp-op: " + std::string(comp_op));
+                return -1;
+            }
+            if (dst->i->width() != src->i->width() || dst->i->height() != src->i->height()) {
+                dst->err = new std::string("image sizes differ");
+                return -1;
+            }
+            mapnik::premultiply_alpha(*dst->i);
+            mapnik::premultiply_alpha(*src->i);
+            mapnik::composite(*dst->i, *src->i, *mode, opacity);
+        } catch (std::exception const& ex) {
+            dst->err = new std::string(ex.what());
+            return -1;
+        }
+        return 0;
+    }
+    return -1;
+}
+
+void mapnik_image_demultiply(mapnik_image_t * i) {
+    if (i && i->i) {
+        mapnik::demultiply_alpha(*i->i);
+    }
+}
+
+mapnik_image_t * mapnik_image_from_blob(const char * blob, size_t len, size_t max_pixels) {
+    // Always returns an image. Check for errors with mapnik_image_last_error.
+    mapnik_image_t * img = new mapnik_image_t;
+    img->i = NULL;
+    img->err = NULL;
+    try {
+        std::unique_ptr<mapnik::image_reader> reader(mapnik::get_image_reader(blob, len));
+        if (!reader) {
+            img->err = new std::string("unsupported image format");
+            return img;
+        }
+        size_t width = reader->width();
+        size_t height = reader->height();
+        if (max_pixels > 0 && width * height > max_pixels) {
+            img->err = new std::string("image size exceeds limit");
+            return img;
+        }
+        mapnik_rgba_image * im = new mapnik_rgba_image(width, height);
+        try {
+            reader->read(0, 0, *im);
+            if (im->get_premultiplied()) {
+                mapnik::demultiply_alpha(*im);
+            }
+        } catch (...) {
+            delete im;
+            throw;
+        }
+        img->i = im;
+    } catch (std::exception const& ex) {
+        img->err = new std::string(ex.what());
+    }
+    return img;
+}
+
+int mapnik_image_width(mapnik_image_t * i) {
+    if (i && i->i) {
+        return i->i->width();
+    }
+    return 0;
+}
+
+int mapnik_image_height(mapnik_image_t * i) {
+    if (i && i->i) {
+        return i->i->height();
+    }
+    return 0;
+}
+
 int mapnik_map_layer_count(mapnik_map_t * m) {
     if (m && m->m) {
         return m->m->layer_count();
@@ -373,6 +1162,248 @@ void mapnik_map_layer_set_active(mapnik_map_t * m, size_t idx, int active) {
     }
 }
 
+const char * mapnik_map_layer_srs(mapnik_map_t * m, size_t idx) {
+    if (m && m->m) {
+        mapnik::layer const& layer = m->m->get_layer(idx);
+        return layer.srs().c_str();
+    }
+    return NULL;
+}
+
+struct _mapnik_raster_colorizer_t {
+    mapnik::raster_colorizer_ptr c;
+};
+
+mapnik_raster_colorizer_t * mapnik_raster_colorizer(int default_mode, uint8_t r, uint8_t g, uint8_t b, uint8_t a, double epsilon) {
+    mapnik_raster_colorizer_t * c = new mapnik_raster_colorizer_t;
+    c->c = std::make_shared<mapnik::raster_colorizer>(
+               static_cast<mapnik::colorizer_mode_enum>(default_mode), mapnik::color(r, g, b, a));
+    if (epsilon > 0) {
+        c->c->set_epsilon(epsilon);
+    }
+    return c;
+}
+
+void mapnik_raster_colorizer_free(mapnik_raster_colorizer_t * c) {
+    if (c) {
+        delete c;
+    }
+}
+
+int mapnik_raster_colorizer_add_stop(mapnik_raster_colorizer_t * c, double value, int mode,
+                                     uint8_t r, uint8_t g, uint8_t b, uint8_t a, const char * label) {
+    // Returns 0 if the stop was not added, as stops need ascending values.
+    if (c) {
+        return c->c->add_stop(mapnik::colorizer_stop(value, static_cast<mapnik::colorizer_mode_enum>(mode),
+                                                     mapnik::color(r, g, b, a), label ? label : "")) ? 1 : 0;
+    }
+    return 0;
+}
+
+int mapnik_map_set_raster_colorizer(mapnik_map_t * m, const char * style_name, mapnik_raster_colorizer_t * c) {
+    // Sets the colorizer of all raster symbolizers of the style. Returns the
+    // number of updated symbolizers, or -1 if the style does not exist.
+    if (m && m->m && c) {
+        auto style = m->m->styles().find(style_name);
+        if (style == m->m->styles().end()) {
+            return -1;
+        }
+        int n = 0;
+        for (mapnik::rule & r : style->second.get_rules_nonconst()) {
+            for (mapnik::symbolizer & sym : r) {
+                if (sym.is<mapnik::raster_symbolizer>()) {
+                    mapnik::put(sym.get<mapnik::raster_symbolizer>(), mapnik::keys::colorizer, c->c);
+                    n++;
+                }
+            }
+        }
+        return n;
+    }
+    return -1;
+}
+
+int mapnik_map_layer_is_visible(mapnik_map_t * m, size_t idx, double scale_denom) {
+    // Returns 1 if the layer and at least one rule of its styles are visible
+    // at the scale denominator, like the feature_style_processor.
+    if (m && m->m) {
+        mapnik::layer const& layer = m->m->get_layer(idx);
+        if (!layer.visible(scale_denom)) {
+            return 0;
+        }
+        for (std::string const& style_name : layer.styles()) {
+            auto style = m->m->styles().find(style_name);
+            if (style == m->m->styles().end()) {
+                continue;
+            }
+            for (mapnik::rule const& r : style->second.get_rules()) {
+                if (r.active(scale_denom)) {
+                    return 1;
+                }
+            }
+        }
+    }
+    return 0;
+}
+
+const char * mapnik_map_layer_datasource_type(mapnik_map_t * m, size_t idx) {
+    if (m && m->m) {
+        mapnik::parameters const& params = m->m->get_layer(idx).datasource_parameters();
+        auto type = params.find("type");
+        if (type != params.end() && type->second.is<std::string>()) {
+            return type->second.get<std::string>().c_str();
+        }
+        return "";
+    }
+    return NULL;
+}
+
+double mapnik_map_layer_min_scale(mapnik_map_t * m, size_t idx) {
+    if (m && m->m) {
+        return m->m->get_layer(idx).minimum_scale_denominator();
+    }
+    return 0;
+}
+
+double mapnik_map_layer_max_scale(mapnik_map_t * m, size_t idx) {
+    if (m && m->m) {
+        return m->m->get_layer(idx).maximum_scale_denominator();
+    }
+    return 0;
+}
+
+size_t mapnik_map_layer_style_count(mapnik_map_t * m, size_t idx) {
+    if (m && m->m) {
+        return m->m->get_layer(idx).styles().size();
+    }
+    return 0;
+}
+
+const char * mapnik_map_layer_style(mapnik_map_t * m, size_t idx, size_t style_idx) {
+    if (m && m->m) {
+        std::vector<std::string> const& styles = m->m->get_layer(idx).styles();
+        if (style_idx < styles.size()) {
+            return styles[style_idx].c_str();
+        }
+    }
+    return NULL;
+}
+
+int mapnik_map_layer_extent(mapnik_map_t * m, size_t idx, double *x0, double *y0, double *x1, double *y1) {
+    // Returns the extent of the layer datasource in the layer projection.
+    mapnik_map_reset_last_error(m);
+    if (m && m->m) {
+        try {
+            mapnik::box2d<double> extent = m->m->get_layer(idx).envelope();
+            if (!extent.valid()) {
+                return -1;
+            }
+            *x0 = extent.minx();
+            *y0 = extent.miny();
+            *x1 = extent.maxx();
+            *y1 = extent.maxy();
+        } catch (std::exception const& ex) {
+            m->err = new std::string(ex.what());
+            return -1;
+        }
+        return 0;
+    }
+    return -1;
+}
+
+int mapnik_transform_bbox(const char * src, const char * dst, double *x0, double *y0, double *x1, double *y1) {
+    try {
+        mapnik::projection src_prj(src);
+        mapnik::projection dst_prj(dst);
+        mapnik::proj_transform tr(src_prj, dst_prj);
+        mapnik::box2d<double> bbox(*x0, *y0, *x1, *y1);
+        if (!tr.forward(bbox)) {
+            return -1;
+        }
+        *x0 = bbox.minx();
+        *y0 = bbox.miny();
+        *x1 = bbox.maxx();
+        *y1 = bbox.maxy();
+    } catch (std::exception const& ex) {
+        return -1;
+    }
+    return 0;
+}
+
+struct _mapnik_featureset_t {
+    std::vector<mapnik::value_integer> ids;
+    std::vector<std::vector<std::pair<std::string, std::string>>> attrs;
+};
+
+mapnik_featureset_t * mapnik_map_query_point(mapnik_map_t * m, size_t idx, double x, double y) {
+    // Returns all features of the layer at the pixel position x/y.
+    mapnik_map_reset_last_error(m);
+    if (!m || !m->m) {
+        return NULL;
+    }
+    mapnik_featureset_t * result = new mapnik_featureset_t;
+    try {
+        mapnik::featureset_ptr fs = m->m->query_map_point(idx, x, y);
+        if (fs) {
+            mapnik::feature_ptr feat = fs->next();
+            while (feat) {
+                std::vector<std::pair<std::string, std::string>> attrs;
+                for (auto const& kv : *feat) {
+                    attrs.emplace_back(std::get<0>(kv), std::get<1>(kv).to_string());
+                }
+                result->ids.push_back(feat->id());
+                result->attrs.push_back(attrs);
+                feat = fs->next();
+            }
+        }
+    } catch (std::exception const& ex) {
+        delete result;
+        m->err = new std::string(ex.what());
+        return NULL;
+    }
+    return result;
+}
+
+void mapnik_featureset_free(mapnik_featureset_t * fs) {
+    if (fs) {
+        delete fs;
+    }
+}
+
+int mapnik_featureset_count(mapnik_featureset_t * fs) {
+    if (fs) {
+        return fs->ids.size();
+    }
+    return 0;
+}
+
+int64_t mapnik_featureset_feature_id(mapnik_featureset_t * fs, size_t idx) {
+    if (fs && idx < fs->ids.size()) {
+        return fs->ids[idx];
+    }
+    return 0;
+}
+
+int mapnik_featureset_attr_count(mapnik_featureset_t * fs, size_t idx) {
+    if (fs && idx < fs->attrs.size()) {
+        return fs->attrs[idx].size();
+    }
+    return 0;
+}
+
+const char * mapnik_featureset_attr_name(mapnik_featureset_t * fs, size_t idx, size_t attr) {
+    if (fs && idx < fs->attrs.size() && attr < fs->attrs[idx].size()) {
+        return fs->attrs[idx][attr].first.c_str();
+    }
+    return NULL;
+}
+
+const char * mapnik_featureset_attr_value(mapnik_featureset_t * fs, size_t idx, size_t attr) {
+    if (fs && idx < fs->attrs.size() && attr < fs->attrs[idx].size()) {
+        return fs->attrs[idx][attr].second.c_str();
+    }
+    return NULL;
+}
+
 int mapnik_map_background(mapnik_map_t * m, uint8_t *r, uint8_t *g, uint8_t *b, uint8_t *a) {
     if (m && m->m) {
 #if MAPNIK_VERSION > 400000
@@ -416,6 +1447,220 @@ void mapnik_map_reset_maximum_extent(mapnik_map_t * m) {
     }
 }
 
+struct _mapnik_legend_t {
+    struct item {
+        std::string layer;
+        std::string style;
+        std::string rule;
+        std::string filter;
+        mapnik_rgba_image image;
+    };
+    std::vector<item> items;
+};
+
+enum legend_geometry_type {
+    LEGEND_POINT,
+    LEGEND_LINE,
+    LEGEND_POLYGON,
+};
+
+static legend_geometry_type legend_geometry(mapnik::rule const& r) {
+    // Polygons have precedence, so that outlines are drawn around the fill.
+    legend_geometry_type type = LEGEND_POINT;
+    for (auto const& sym : r.get_symbolizers()) {
+        if (sym.is<mapnik::polygon_symbolizer>() ||
+                sym.is<mapnik::polygon_pattern_symbolizer>() ||
+                sym.is<mapnik::building_symbolizer>()) {
+            return LEGEND_POLYGON;
+        }
+        if (sym.is<mapnik::line_symbolizer>() ||
+                sym.is<mapnik::line_pattern_symbolizer>()) {
+            type = LEGEND_LINE;
+        }
+    }
+    return type;
+}
+
+static void legend_set_geometry(mapnik::feature_impl & feature, legend_geometry_type type, double width, double height) {
+    double inset = std::max(1.0, std::min(width, height) / 8.0);
+    switch (type) {
+    case LEGEND_POLYGON: {
+        mapnik::geometry::linear_ring<double> ring;
+        ring.emplace_back(inset, inset);
+        ring.emplace_back(width - inset, inset);
+        ring.emplace_back(width - inset, height - inset);
+        ring.emplace_back(inset, height - inset);
+        ring.emplace_back(inset, inset);
+        mapnik::geometry::polygon<double> poly;
+#if MAPNIK_VERSION >= 300100
+        poly.push_back(std::move(ring));
+#else
+        poly.set_exterior_ring(std::move(ring));
+#endif
+        feature.set_geometry(std::move(poly));
+        break;
+    }
+    case LEGEND_LINE: {
+        mapnik::geometry::line_string<double> line;
+        line.emplace_back(inset, height / 2.0);
+        line.emplace_back(width - inset, height / 2.0);
+        feature.set_geometry(std::move(line));
+        break;
+    }
+    default:
+        feature.set_geometry(mapnik::geometry::point<double>(width / 2.0, height / 2.0));
+        break;
+    }
+}
+
+static mapnik::Map legend_swatch_map(mapnik::Map const& src, unsigned width, unsigned height) {
+    // Returns an empty map for the swatches with the SRS and the fonts of
+    // src, so that fontsets and font files of the map are available.
+    mapnik::Map swatch(width, height, src.srs());
+#if MAPNIK_VERSION > 400000
+    std::optional<std::string> const& font_directory = src.font_directory();
+#else
+    boost::optional<std::string> const& font_directory = src.font_directory();
+#endif
+    if (font_directory) {
+        swatch.set_font_directory(*font_directory);
+        swatch.register_fonts(*font_directory, false);
+    }
+    for (auto const& fontset : src.fontsets()) {
+        swatch.insert_fontset(fontset.first, fontset.second);
+    }
+    swatch.zoom_to_box(mapnik::box2d<double>(0, 0, width, height));
+    return swatch;
+}
+
+static void legend_render_swatch(mapnik::Map & swatch, mapnik::feature_type_style const& src_style, mapnik::rule const& r,
+                                 double scale_factor, mapnik_rgba_image & im) {
+    // Renders the symbolizers of the rule with a single feature. Replaces
+    // the style and layer of the previous swatch.
+    unsigned width = im.width();
+    unsigned height = im.height();
+    swatch.layers().clear();
+    swatch.styles().clear();
+
+    mapnik::feature_type_style style(src_style);
+    style.get_rules_nonconst().clear();
+    mapnik::rule legend_rule;
+    for (auto const& sym : r.get_symbolizers()) {
+        legend_rule.append(sym);
+    }
+    style.add_rule(std::move(legend_rule));
+    swatch.insert_style("legend", std::move(style));
+
+    mapnik::context_ptr ctx = std::make_shared<mapnik::context_type>();
+    mapnik::feature_ptr feature(mapnik::feature_factory::create(ctx, 1));
+    legend_set_geometry(*feature, legend_geometry(r), width, height);
+    mapnik::parameters params;
+    params["type"] = "memory";
+    std::shared_ptr<mapnik::memory_datasource> ds = std::make_shared<mapnik::memory_datasource>(params);
+    ds->push(feature);
+
+    mapnik::layer lyr("legend", swatch.srs());
+    lyr.set_datasource(ds);
+    lyr.add_style("legend");
+    swatch.add_layer(std::move(lyr));
+
+    mapnik::agg_renderer<mapnik_rgba_image> ren(swatch, im, scale_factor);
+    ren.apply();
+}
+
+mapnik_legend_t * mapnik_map_legend(mapnik_map_t * m, unsigned int width, unsigned int height, double scale, double scale_factor) {
+    // Renders a swatch for each rule of the active layers. Rules and layers
+    // outside of scale are skipped if scale is > 0.
+    mapnik_map_reset_last_error(m);
+    if (!m || !m->m) {
+        return NULL;
+    }
+    mapnik_legend_t * legend = new mapnik_legend_t;
+    try {
+        mapnik::Map swatch = legend_swatch_map(*m->m, width, height);
+        for (mapnik::layer const& layer : m->m->layers()) {
+            if (!layer.active() || (scale > 0.0 && !layer.visible(scale))) {
+                continue;
+            }
+            for (std::string const& style_name : layer.styles()) {
+                auto style = m->m->styles().find(style_name);
+                if (style == m->m->styles().end()) {
+                    continue;
+                }
+                for (mapnik::rule const& r : style->second.get_rules()) {
+                    if (scale > 0.0 && !r.active(scale)) {
+                        continue;
+                    }
+                    _mapnik_legend_t::item item = {layer.name(), style_name, r.get_name(), "", mapnik_rgba_image(width, height)};
+                    if (r.has_else_filter()) {
+                        item.filter = "else";
+                    } else if (r.has_also_filter()) {
+                        item.filter = "also";
+                    } else {
+                        item.filter = mapnik::to_expression_string(*r.get_filter());
+                    }
+                    legend_render_swatch(swatch, style->second, r, scale_factor, item.image);
+                    legend->items.push_back(std::move(item));
+                }
+            }
+        }
+    } catch (std::exception const& ex) {
+        delete legend;
+        m->err = new std::string(ex.what());
+        return NULL;
+    }
+    return legend;
+}
+
+void mapnik_legend_free(mapnik_legend_t * l) {
+    if (l) {
+        delete l;
+    }
+}
+
+int mapnik_legend_count(mapnik_legend_t * l) {
+    if (l) {
+        return l->items.size();
+    }
+    return 0;
+}
+
+const char * mapnik_legend_layer(mapnik_legend_t * l, size_t idx) {
+    if (l && idx < l->items.size()) {
+        return l->items[idx].layer.c_str();
+    }
+    return NULL;
+}
+
+const char * mapnik_legend_style(mapnik_legend_t * l, size_t idx) {
+    if (l && idx < l->items.size()) {
+        return l->items[idx].style.c_str();
+    }
+    return NULL;
+}
+
+const char * mapnik_legend_rule(mapnik_legend_t * l, size_t idx) {
+    if (l && idx < l->items.size()) {
+        return l->items[idx].rule.c_str();
+    }
+    return NULL;
+}
+
+const char * mapnik_legend_filter(mapnik_legend_t * l, size_t idx) {
+    if (l && idx < l->items.size()) {
+        return l->items[idx].filter.c_str();
+    }
+    return NULL;
+}
+
+const uint8_t * mapnik_legend_image_raw(mapnik_legend_t * l, size_t idx, size_t * size) {
+    if (l && idx < l->items.size()) {
+        mapnik_rgba_image const& im = l->items[idx].image;
+        *size = im.width() * im.height() * 4;
+        return (const uint8_t *)im.data();
+    }
+    return NULL;
+}
 
 #ifdef __cplusplus
 }
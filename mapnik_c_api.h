@@ -15,18 +15,21 @@ extern "C"
 {
 #endif
 
-const int mapnik_version = MAPNIK_VERSION;
-const int mapnik_version_major = MAPNIK_MAJOR_VERSION;
-const int mapnik_version_minor = MAPNIK_MINOR_VERSION;
-const int mapnik_version_patch = MAPNIK_PATCH_VERSION;
+static const int mapnik_version = MAPNIK_VERSION;
+static const int mapnik_version_major = MAPNIK_MAJOR_VERSION;
+static const int mapnik_version_minor = MAPNIK_MINOR_VERSION;
+static const int mapnik_version_patch = MAPNIK_PATCH_VERSION;
 
 MAPNIKCAPICALL int mapnik_register_datasource(const char* path);
 MAPNIKCAPICALL int mapnik_register_font(const char* path);
+MAPNIKCAPICALL char * mapnik_font_face_names();
+MAPNIKCAPICALL int mapnik_srs_is_valid(const char * srs);
+MAPNIKCAPICALL int mapnik_srs_is_geographic(const char * srs);
 
-const int MAPNIK_NONE = 0;
-const int MAPNIK_DEBUG = 1;
-const int MAPNIK_WARN = 2;
-const int MAPNIK_ERROR = 3;
+static const int MAPNIK_NONE = 0;
+static const int MAPNIK_DEBUG = 1;
+static const int MAPNIK_WARN = 2;
+static const int MAPNIK_ERROR = 3;
 
 MAPNIKCAPICALL void mapnik_logging_set_severity(int);
 
@@ -52,18 +55,42 @@ MAPNIKCAPICALL void mapnik_image_blob_free(mapnik_image_blob_t * b);
 
 MAPNIKCAPICALL mapnik_image_blob_t * mapnik_image_to_blob(mapnik_image_t * i, const char * format);
 
+// Palette
+typedef struct _mapnik_palette_t mapnik_palette_t;
+
+static const int MAPNIK_PALETTE_RGBA = 0;
+static const int MAPNIK_PALETTE_RGB = 1;
+static const int MAPNIK_PALETTE_ACT = 2;
+
+MAPNIKCAPICALL mapnik_palette_t * mapnik_palette(const char * data, size_t len, int type);
+MAPNIKCAPICALL void mapnik_palette_free(mapnik_palette_t * p);
+MAPNIKCAPICALL const char * mapnik_palette_last_error(mapnik_palette_t * p);
+MAPNIKCAPICALL int mapnik_palette_colors(mapnik_palette_t * p, uint8_t * rgba);
+MAPNIKCAPICALL int mapnik_image_quantize(mapnik_image_t * i, mapnik_palette_t * p, uint8_t * indices);
+
+MAPNIKCAPICALL mapnik_image_blob_t * mapnik_image_to_blob_with_palette(mapnik_image_t * i, const char * format, mapnik_palette_t * p);
+
 MAPNIKCAPICALL const uint8_t * mapnik_image_to_raw(mapnik_image_t * i, size_t *size);
 MAPNIKCAPICALL mapnik_image_t * mapnik_image_from_raw(const uint8_t * raw, int width, int height);
+MAPNIKCAPICALL mapnik_image_t * mapnik_image_from_blob(const char * blob, size_t len, size_t max_pixels);
+MAPNIKCAPICALL mapnik_image_t * mapnik_image_blank(int width, int height);
+MAPNIKCAPICALL int mapnik_comp_op_is_valid(const char * comp_op);
+MAPNIKCAPICALL int mapnik_image_composite(mapnik_image_t * dst, mapnik_image_t * src, const char * comp_op, double opacity);
+MAPNIKCAPICALL void mapnik_image_demultiply(mapnik_image_t * i);
+MAPNIKCAPICALL int mapnik_image_width(mapnik_image_t * i);
+MAPNIKCAPICALL int mapnik_image_height(mapnik_image_t * i);
 
 //  Map
 typedef struct _mapnik_map_t mapnik_map_t;
 
 MAPNIKCAPICALL mapnik_map_t * mapnik_map( unsigned int width, unsigned int height );
+MAPNIKCAPICALL mapnik_map_t * mapnik_map_clone(mapnik_map_t * m);
 MAPNIKCAPICALL void mapnik_map_free(mapnik_map_t * m);
 
 MAPNIKCAPICALL const char * mapnik_map_last_error(mapnik_map_t * m);
 
 MAPNIKCAPICALL int mapnik_map_load(mapnik_map_t * m, const char* stylesheet);
+MAPNIKCAPICALL int mapnik_map_load_opts(mapnik_map_t * m, const char* stylesheet, int strict, const char* base_path);
 MAPNIKCAPICALL void mapnik_apply_layer_off_hack(mapnik_map_t * m);
 
 MAPNIKCAPICALL const char * mapnik_map_get_srs(mapnik_map_t * m);
@@ -76,18 +103,105 @@ MAPNIKCAPICALL int mapnik_map_background(mapnik_map_t * m, uint8_t *r, uint8_t *
 MAPNIKCAPICALL void mapnik_map_set_background(mapnik_map_t * m, uint8_t r, uint8_t g, uint8_t b, uint8_t a);
 
 MAPNIKCAPICALL int mapnik_map_zoom_all(mapnik_map_t * m);
+MAPNIKCAPICALL void mapnik_map_current_extent(mapnik_map_t * m, double *x0, double *y0, double *x1, double *y1);
 MAPNIKCAPICALL void mapnik_map_zoom_to_box(mapnik_map_t * m, mapnik_bbox_t * b);
 
 MAPNIKCAPICALL void mapnik_map_set_maximum_extent(mapnik_map_t * m, double x0, double y0, double x1, double y1);
 MAPNIKCAPICALL void mapnik_map_reset_maximum_extent(mapnik_map_t * m);
 
 MAPNIKCAPICALL int mapnik_map_render_to_file(mapnik_map_t * m, const char* filepath, double scale, double scale_factor, const char *format);
+MAPNIKCAPICALL int mapnik_map_render_to_file_opts(mapnik_map_t * m, const char* filepath, double scale, double scale_factor, const char *format,
+        const int * active);
 MAPNIKCAPICALL mapnik_image_t * mapnik_map_render_to_image(mapnik_map_t * m, double scale, double scale_factor);
 
+// Render stats
+typedef struct _mapnik_render_stats_t mapnik_render_stats_t;
+
+static const int MAPNIK_LAYER_RENDERED = 0;
+static const int MAPNIK_LAYER_SKIPPED_SCALE = 1;
+static const int MAPNIK_LAYER_SKIPPED_EXTENT = 2;
+
+MAPNIKCAPICALL mapnik_render_stats_t * mapnik_render_stats();
+MAPNIKCAPICALL void mapnik_render_stats_free(mapnik_render_stats_t * stats);
+MAPNIKCAPICALL double mapnik_render_stats_seconds(mapnik_render_stats_t * stats);
+MAPNIKCAPICALL int mapnik_render_stats_layer_count(mapnik_render_stats_t * stats);
+MAPNIKCAPICALL const char * mapnik_render_stats_layer_name(mapnik_render_stats_t * stats, size_t idx);
+MAPNIKCAPICALL double mapnik_render_stats_layer_seconds(mapnik_render_stats_t * stats, size_t idx);
+MAPNIKCAPICALL size_t mapnik_render_stats_layer_fetched(mapnik_render_stats_t * stats, size_t idx);
+MAPNIKCAPICALL size_t mapnik_render_stats_layer_rendered(mapnik_render_stats_t * stats, size_t idx);
+MAPNIKCAPICALL int mapnik_render_stats_layer_status(mapnik_render_stats_t * stats, size_t idx);
+
+// Placed labels
+typedef struct _mapnik_labels_t mapnik_labels_t;
+
+MAPNIKCAPICALL mapnik_labels_t * mapnik_labels();
+MAPNIKCAPICALL void mapnik_labels_free(mapnik_labels_t * labels);
+MAPNIKCAPICALL size_t mapnik_labels_count(mapnik_labels_t * labels);
+MAPNIKCAPICALL const char * mapnik_labels_layer(mapnik_labels_t * labels, size_t idx);
+MAPNIKCAPICALL int64_t mapnik_labels_feature_id(mapnik_labels_t * labels, size_t idx);
+MAPNIKCAPICALL void mapnik_labels_box(mapnik_labels_t * labels, size_t idx, double * x0, double * y0, double * x1, double * y1);
+MAPNIKCAPICALL const char * mapnik_labels_text(mapnik_labels_t * labels, size_t idx);
+
+// Label collision detector
+typedef struct _mapnik_label_detector_t mapnik_label_detector_t;
+
+MAPNIKCAPICALL mapnik_label_detector_t * mapnik_label_detector(double x0, double y0, double x1, double y1);
+MAPNIKCAPICALL void mapnik_label_detector_free(mapnik_label_detector_t * d);
+MAPNIKCAPICALL void mapnik_label_detector_insert(mapnik_label_detector_t * d, double x0, double y0, double x1, double y1);
+MAPNIKCAPICALL void mapnik_label_detector_clear(mapnik_label_detector_t * d);
+
+MAPNIKCAPICALL mapnik_image_t * mapnik_map_render_to_image_opts(mapnik_map_t * m, double scale, double scale_factor, mapnik_render_stats_t * stats,
+        mapnik_labels_t * labels, mapnik_label_detector_t * d, const int * active);
+
+// Raster colorizer
+typedef struct _mapnik_raster_colorizer_t mapnik_raster_colorizer_t;
+
+static const int MAPNIK_COLORIZER_INHERIT = 0;
+static const int MAPNIK_COLORIZER_LINEAR = 1;
+static const int MAPNIK_COLORIZER_DISCRETE = 2;
+static const int MAPNIK_COLORIZER_EXACT = 3;
+
+MAPNIKCAPICALL mapnik_raster_colorizer_t * mapnik_raster_colorizer(int default_mode, uint8_t r, uint8_t g, uint8_t b, uint8_t a, double epsilon);
+MAPNIKCAPICALL void mapnik_raster_colorizer_free(mapnik_raster_colorizer_t * c);
+MAPNIKCAPICALL int mapnik_raster_colorizer_add_stop(mapnik_raster_colorizer_t * c, double value, int mode,
+        uint8_t r, uint8_t g, uint8_t b, uint8_t a, const char * label);
+MAPNIKCAPICALL int mapnik_map_set_raster_colorizer(mapnik_map_t * m, const char * style_name, mapnik_raster_colorizer_t * c);
+
 MAPNIKCAPICALL int mapnik_map_layer_count(mapnik_map_t * m);
 MAPNIKCAPICALL const char * mapnik_map_layer_name(mapnik_map_t * m, size_t idx);
 MAPNIKCAPICALL int mapnik_map_layer_is_active(mapnik_map_t * m, size_t idx);
 MAPNIKCAPICALL void mapnik_map_layer_set_active(mapnik_map_t * m, size_t idx, int active);
+MAPNIKCAPICALL const char * mapnik_map_layer_srs(mapnik_map_t * m, size_t idx);
+MAPNIKCAPICALL int mapnik_map_layer_is_visible(mapnik_map_t * m, size_t idx, double scale_denom);
+MAPNIKCAPICALL const char * mapnik_map_layer_datasource_type(mapnik_map_t * m, size_t idx);
+MAPNIKCAPICALL double mapnik_map_layer_min_scale(mapnik_map_t * m, size_t idx);
+MAPNIKCAPICALL double mapnik_map_layer_max_scale(mapnik_map_t * m, size_t idx);
+MAPNIKCAPICALL size_t mapnik_map_layer_style_count(mapnik_map_t * m, size_t idx);
+MAPNIKCAPICALL const char * mapnik_map_layer_style(mapnik_map_t * m, size_t idx, size_t style_idx);
+MAPNIKCAPICALL int mapnik_map_layer_extent(mapnik_map_t * m, size_t idx, double *x0, double *y0, double *x1, double *y1);
+
+MAPNIKCAPICALL int mapnik_transform_bbox(const char * src, const char * dst, double *x0, double *y0, double *x1, double *y1);
+
+// Features
+typedef struct _mapnik_featureset_t mapnik_featureset_t;
+MAPNIKCAPICALL mapnik_featureset_t * mapnik_map_query_point(mapnik_map_t * m, size_t idx, double x, double y);
+MAPNIKCAPICALL void mapnik_featureset_free(mapnik_featureset_t * fs);
+MAPNIKCAPICALL int mapnik_featureset_count(mapnik_featureset_t * fs);
+MAPNIKCAPICALL int64_t mapnik_featureset_feature_id(mapnik_featureset_t * fs, size_t idx);
+MAPNIKCAPICALL int mapnik_featureset_attr_count(mapnik_featureset_t * fs, size_t idx);
+MAPNIKCAPICALL const char * mapnik_featureset_attr_name(mapnik_featureset_t * fs, size_t idx, size_t attr);
+MAPNIKCAPICALL const char * mapnik_featureset_attr_value(mapnik_featureset_t * fs, size_t idx, size_t attr);
+
+// Legend
+typedef struct _mapnik_legend_t mapnik_legend_t;
+MAPNIKCAPICALL mapnik_legend_t * mapnik_map_legend(mapnik_map_t * m, unsigned int width, unsigned int height, double scale, double scale_factor);
+MAPNIKCAPICALL void mapnik_legend_free(mapnik_legend_t * l);
+MAPNIKCAPICALL int mapnik_legend_count(mapnik_legend_t * l);
+MAPNIKCAPICALL const char * mapnik_legend_layer(mapnik_legend_t * l, size_t idx);
+MAPNIKCAPICALL const char * mapnik_legend_style(mapnik_legend_t * l, size_t idx);
+MAPNIKCAPICALL const char * mapnik_legend_rule(mapnik_legend_t * l, size_t idx);
+MAPNIKCAPICALL const char * mapnik_legend_filter(mapnik_legend_t * l, size_t idx);
+MAPNIKCAPICALL const uint8_t * mapnik_legend_image_raw(mapnik_legend_t * l, size_t idx, size_t * size);
 
 #ifdef __cplusplus
 }
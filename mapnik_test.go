@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -31,6 +32,48 @@ func TestMap(t *testing.T) {
 	}
 }
 
+func TestLoadWithOpts(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "style.xml")
+	style := `<Map srs="epsg:4326">
+    <Style name="style"><Rule><PolygonSymbolizer fill="red" unknown="1" /></Rule></Style>
+    <Layer name="layer" srs="epsg:4326">
+        <StyleName>style</StyleName>
+        <Datasource>
+            <Parameter name="file">map.geojson</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+</Map>`
+	if err := os.WriteFile(fname, []byte(style), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testDir, err := filepath.Abs("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New()
+	defer m.Free()
+	// map.geojson is not in the directory of the stylesheet
+	if err := m.Load(fname); err == nil {
+		t.Error("expected error for missing datasource file")
+	}
+
+	m = New()
+	defer m.Free()
+	if err := m.LoadWithOpts(fname, LoadOpts{BasePath: testDir}); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(m.Layers()))
+
+	m = New()
+	defer m.Free()
+	if err := m.LoadWithOpts(fname, LoadOpts{BasePath: testDir, Strict: true}); err == nil {
+		t.Error("expected error for unknown attribute in strict mode")
+	}
+}
+
 func TestRenderFile(t *testing.T) {
 	m := New()
 	if err := m.Load("test/map.xml"); err != nil {
@@ -157,6 +200,36 @@ func TestRender(t *testing.T) {
 	}
 }
 
+func TestRenderMulti(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	formats := []string{"png24", "jpeg80", "webp"}
+	result, err := m.RenderMulti(RenderOpts{}, formats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != len(formats) {
+		t.Fatal("unexpected number of results", len(result))
+	}
+	for _, format := range formats {
+		expected, err := m.Render(RenderOpts{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(expected, result[format]) {
+			t.Error("RenderMulti and Render output differs for", format)
+		}
+	}
+
+	if _, err := m.RenderMulti(RenderOpts{}, []string{"png", "invalidformat"}); err == nil {
+		t.Fatal("invalid format did not return an error")
+	}
+}
+
 type testSelector struct {
 	status func(string) Status
 }
@@ -216,6 +289,150 @@ func TestLayerStatus(t *testing.T) {
 
 }
 
+func TestRenderLayers(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	for _, tc := range []struct {
+		layers   []string
+		exclude  []string
+		rendered []string
+	}{
+		{nil, nil, []string{"layerA", "layerB", "layerC"}},
+		{[]string{"layerA", "layerD", "unknown"}, nil, []string{"layerA", "layerD"}},
+		{nil, []string{"layerB"}, []string{"layerA", "layerC"}},
+		{[]string{"layerA", "layerB"}, []string{"layerB"}, []string{"layerA"}},
+		{[]string{}, nil, nil},
+		{[]string{"unknown"}, nil, nil},
+	} {
+		stats := RenderStats{}
+		if _, err := m.RenderImage(RenderOpts{Layers: tc.layers, ExcludeLayers: tc.exclude, CollectStats: &stats}); err != nil {
+			t.Fatal(err)
+		}
+		var rendered []string
+		for _, l := range stats.Layers {
+			rendered = append(rendered, l.Name)
+		}
+		assertEqual(t, tc.rendered, rendered)
+		assertEqual(t, []bool{true, true, true, false}, m.currentLayerStatus())
+	}
+}
+
+func TestRenderLayersConcurrent(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	wg := sync.WaitGroup{}
+	for _, layers := range [][]string{{"layerA"}, {"layerB", "layerD"}, {"layerC"}, nil} {
+		wg.Add(1)
+		go func(layers []string) {
+			defer wg.Done()
+			expected := layers
+			if expected == nil {
+				expected = []string{"layerA", "layerB", "layerC"}
+			}
+			for i := 0; i < 10; i++ {
+				stats := RenderStats{}
+				if _, err := m.RenderImage(RenderOpts{Layers: layers, CollectStats: &stats}); err != nil {
+					t.Error(err)
+					return
+				}
+				var rendered []string
+				for _, l := range stats.Layers {
+					rendered = append(rendered, l.Name)
+				}
+				if !reflect.DeepEqual(expected, rendered) {
+					t.Errorf("unexpected layers for %v: %v", layers, rendered)
+					return
+				}
+			}
+		}(layers)
+	}
+	wg.Wait()
+	assertEqual(t, []bool{true, true, true, false}, m.currentLayerStatus())
+}
+
+func TestClone(t *testing.T) {
+	m := NewSized(200, 100)
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+	m.SelectLayers(SelectorFunc(func(layer string) Status {
+		if layer == "layerA" {
+			return Exclude
+		}
+		return Default
+	}))
+
+	c := m.Clone()
+	defer c.Free()
+	assertEqual(t, m.width, c.width)
+	assertEqual(t, m.height, c.height)
+	assertEqual(t, m.layerStatus, c.layerStatus)
+	assertEqual(t, m.currentLayerStatus(), c.currentLayerStatus())
+	assertEqual(t, m.SRS(), c.SRS())
+
+	expected, err := m.RenderImage(RenderOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Free()
+	img, err := c.RenderImage(RenderOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertImageEqual(t, expected, img)
+
+	// reset to the layer status of the original map
+	c.ResetLayers()
+	assertEqual(t, []bool{true, true, true, false}, c.currentLayerStatus())
+}
+
+func TestFree(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.Free()
+	m.Free()
+
+	assertEqual(t, ErrMapFreed, m.Load("test/map.xml"))
+	assertEqual(t, ErrMapFreed, m.ZoomAll())
+	if _, err := m.Render(RenderOpts{}); err != ErrMapFreed {
+		t.Error("unexpected error", err)
+	}
+	if _, err := m.RenderImage(RenderOpts{}); err != ErrMapFreed {
+		t.Error("unexpected error", err)
+	}
+	if _, err := m.QueryPoint("layerA", 0, 0); err != ErrMapFreed {
+		t.Error("unexpected error", err)
+	}
+	assertEqual(t, ErrMapFreed, m.RenderToFile(RenderOpts{}, filepath.Join(t.TempDir(), "out.png")))
+
+	// methods without errors are no-ops
+	m.Resize(100, 100)
+	m.ZoomTo(0, 0, 1, 1)
+	m.SetSRS("epsg:3857")
+	m.SetBufferSize(10)
+	assertEqual(t, "", m.SRS())
+	assertEqual(t, 0.0, m.ScaleDenominator())
+	assertEqual(t, []Layer(nil), m.Layers())
+	assertEqual(t, false, m.SelectLayers(SelectorFunc(func(string) Status { return Include })))
+	m.ResetLayers()
+
+	c := m.Clone()
+	assertEqual(t, ErrMapFreed, c.ZoomAll())
+}
+
 func prepareImg(t testing.TB) *image.NRGBA {
 	r, err := os.Open("test/encode_test.png")
 	if err != nil {
@@ -289,6 +506,19 @@ func TestToNRGBA_FromNRGBA(t *testing.T) {
 	}
 }
 
+func TestToNRGBA_FromSubImage(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	img.Set(10, 10, color.NRGBA{255, 0, 0, 255})
+	sub := img.SubImage(image.Rect(10, 10, 15, 15)).(*image.NRGBA)
+	nrgba := toNRGBA(sub)
+
+	if nrgba.Stride != 5*4 || nrgba.Rect != image.Rect(0, 0, 5, 5) {
+		t.Error("unexpected stride or size", nrgba.Stride, nrgba.Rect)
+	}
+	assertEqual(t, color.NRGBA{255, 0, 0, 255}, nrgba.At(0, 0))
+	assertEqual(t, color.NRGBA{0, 0, 0, 0}, nrgba.At(1, 0))
+}
+
 func TestToNRGBA_FromRGBA(t *testing.T) {
 	// color information gets lots when converting from RGBA to NRGBA,
 	// especially for colors with low opacity
@@ -0,0 +1,240 @@
+package mapnik
+
+import (
+	"bytes"
+	"expvar"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrorCategory classifies errors for Metrics.
+type ErrorCategory string
+
+const (
+	// ErrorFormat is reported for invalid formats or encode options.
+	ErrorFormat ErrorCategory = "format"
+	// ErrorRender is reported for failed renderings.
+	ErrorRender ErrorCategory = "render"
+	// ErrorEncode is reported for failed encodings.
+	ErrorEncode ErrorCategory = "encode"
+	// ErrorWrite is reported if RenderToFile is unable to write the file.
+	ErrorWrite ErrorCategory = "write"
+)
+
+// Metrics receives measurements of all renderings and encodings. Register
+// an implementation with SetMetrics. Implementations need to be safe for
+// concurrent use.
+type Metrics interface {
+	// Render is called after each successful rendering with the base of
+	// the requested format (png, jpeg, webp, tiff or raw) and the duration
+	// of the rendering without encoding. The format is "image" for
+	// RenderImage and RenderPaletted, "multi" for RenderMulti and
+	// "composite" for Composite. The duration includes the encoding for
+	// RenderToFile.
+	Render(format string, d time.Duration)
+	// Encode is called after each successful encoding with the base format,
+	// the duration and the size of the encoded image in bytes.
+	Encode(format string, d time.Duration, size int)
+	// Error is called for each failed rendering or encoding.
+	Error(category ErrorCategory)
+	// PoolWait is called by ObservePoolWait.
+	PoolWait(d time.Duration)
+}
+
+// metrics contains a metricsHolder, as atomic.Value can't store nil.
+var metrics atomic.Value
+
+type metricsHolder struct {
+	m Metrics
+}
+
+// SetMetrics registers the Metrics for all following renderings and
+// encodings. Metrics are disabled if m is nil.
+func SetMetrics(m Metrics) {
+	metrics.Store(metricsHolder{m})
+}
+
+func currentMetrics() Metrics {
+	h, _ := metrics.Load().(metricsHolder)
+	return h.m
+}
+
+// ObservePoolWait reports the time a request waited for a Map from a pool
+// to the registered Metrics. The wms and tileserver packages report their
+// pools.
+func ObservePoolWait(d time.Duration) {
+	if m := currentMetrics(); m != nil {
+		m.PoolWait(d)
+	}
+}
+
+func observeRender(format string, start time.Time) {
+	if m := currentMetrics(); m != nil {
+		m.Render(metricsFormat(format), time.Since(start))
+	}
+}
+
+func observeEncode(format string, start time.Time, size int) {
+	if m := currentMetrics(); m != nil {
+		m.Encode(metricsFormat(format), time.Since(start), size)
+	}
+}
+
+func observeError(category ErrorCategory) {
+	if m := currentMetrics(); m != nil {
+		m.Error(category)
+	}
+}
+
+// baseFormats are the image formats of Mapnik. Format strings start with
+// the base format, followed by the variant and options (e.g. png8:z=9).
+var baseFormats = []string{"png", "jpeg", "webp", "tiff"}
+
+// baseFormat returns the base format of a Mapnik format string, or an empty
+// string for unknown formats.
+func baseFormat(format string) string {
+	format = strings.ToLower(format)
+	for _, f := range baseFormats {
+		if strings.HasPrefix(format, f) {
+			return f
+		}
+	}
+	if strings.HasPrefix(format, "tif") {
+		return "tiff"
+	}
+	if format == "raw" {
+		return "raw"
+	}
+	return ""
+}
+
+// metricsFormat returns the base format for Metrics to limit the number of
+// distinct formats. Other names, like "image", are returned unchanged.
+func metricsFormat(format string) string {
+	if f := baseFormat(format); f != "" {
+		return f
+	}
+	return format
+}
+
+// ExpvarMetrics is a Metrics implementation that publishes all measurements
+// with the expvar package. Durations are published as histograms with
+// cumulative bucket counts in seconds, similar to Prometheus histograms.
+type ExpvarMetrics struct {
+	mu            sync.Mutex
+	renders       *expvar.Map
+	renderSeconds *expvar.Map
+	encodeSeconds *expvar.Map
+	bytes         *expvar.Map
+	errors        *expvar.Map
+	poolWait      *histogram
+}
+
+// NewExpvarMetrics publishes a new expvar.Map with all metrics under name.
+// Like expvar.NewMap, it panics if name is already registered.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	e := &ExpvarMetrics{
+		renders:       new(expvar.Map).Init(),
+		renderSeconds: new(expvar.Map).Init(),
+		encodeSeconds: new(expvar.Map).Init(),
+		bytes:         new(expvar.Map).Init(),
+		errors:        new(expvar.Map).Init(),
+		poolWait:      newHistogram(),
+	}
+	m := expvar.NewMap(name)
+	m.Set("renders", e.renders)
+	m.Set("render_seconds", e.renderSeconds)
+	m.Set("encode_seconds", e.encodeSeconds)
+	m.Set("bytes", e.bytes)
+	m.Set("errors", e.errors)
+	m.Set("pool_wait_seconds", e.poolWait)
+	return e
+}
+
+func (e *ExpvarMetrics) Render(format string, d time.Duration) {
+	e.renders.Add(format, 1)
+	e.histogram(e.renderSeconds, format).observe(d)
+}
+
+func (e *ExpvarMetrics) Encode(format string, d time.Duration, size int) {
+	e.bytes.Add(format, int64(size))
+	e.histogram(e.encodeSeconds, format).observe(d)
+}
+
+func (e *ExpvarMetrics) Error(category ErrorCategory) {
+	e.errors.Add(string(category), 1)
+}
+
+func (e *ExpvarMetrics) PoolWait(d time.Duration) {
+	e.poolWait.observe(d)
+}
+
+// histogram returns the histogram for key and creates it if necessary.
+func (e *ExpvarMetrics) histogram(m *expvar.Map, key string) *histogram {
+	if h, ok := m.Get(key).(*histogram); ok {
+		return h
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if h, ok := m.Get(key).(*histogram); ok {
+		return h
+	}
+	h := newHistogram()
+	m.Set(key, h)
+	return h
+}
+
+// histogramBuckets are the upper bounds of the histogram buckets in seconds.
+var histogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram is an expvar.Var for durations.
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(histogramBuckets))}
+}
+
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range histogramBuckets {
+		if s <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += s
+}
+
+// String returns the histogram as JSON, e.g.
+// {"count": 3, "sum": 0.42, "buckets": {"0.005": 0, ..., "+Inf": 3}}.
+func (h *histogram) String() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	buf := bytes.Buffer{}
+	buf.WriteString(`{"count": `)
+	buf.WriteString(strconv.FormatUint(h.count, 10))
+	buf.WriteString(`, "sum": `)
+	buf.WriteString(strconv.FormatFloat(h.sum, 'g', -1, 64))
+	buf.WriteString(`, "buckets": {`)
+	for i, b := range histogramBuckets {
+		buf.WriteString(`"`)
+		buf.WriteString(strconv.FormatFloat(b, 'g', -1, 64))
+		buf.WriteString(`": `)
+		buf.WriteString(strconv.FormatUint(h.counts[i], 10))
+		buf.WriteString(`, `)
+	}
+	buf.WriteString(`"+Inf": `)
+	buf.WriteString(strconv.FormatUint(h.count, 10))
+	buf.WriteString(`}}`)
+	return buf.String()
+}
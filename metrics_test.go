@@ -0,0 +1,155 @@
+package mapnik
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testMetrics struct {
+	mu       sync.Mutex
+	renders  []string
+	encodes  []string
+	bytes    int
+	errors   []ErrorCategory
+	poolWait int
+}
+
+func (t *testMetrics) Render(format string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renders = append(t.renders, format)
+}
+
+func (t *testMetrics) Encode(format string, d time.Duration, size int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.encodes = append(t.encodes, format)
+	t.bytes += size
+}
+
+func (t *testMetrics) Error(category ErrorCategory) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.errors = append(t.errors, category)
+}
+
+func (t *testMetrics) PoolWait(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.poolWait++
+}
+
+func TestMetrics(t *testing.T) {
+	tm := &testMetrics{}
+	SetMetrics(tm)
+	defer SetMetrics(nil)
+
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	b, err := m.Render(RenderOpts{Format: "png32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.RenderImage(RenderOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Render(RenderOpts{Format: "png", Encoding: JPEGOptions{Quality: 200}}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := m.Render(RenderOpts{Format: "invalid"}); err == nil {
+		t.Fatal("expected error")
+	}
+	if _, err := Encode(prepareImg(t), "jpeg"); err != nil {
+		t.Fatal(err)
+	}
+	ObservePoolWait(time.Millisecond)
+
+	assertEqual(t, []string{"png", "image"}, tm.renders)
+	assertEqual(t, []string{"png", "jpeg"}, tm.encodes)
+	assertEqual(t, []ErrorCategory{ErrorFormat, ErrorFormat}, tm.errors)
+	if tm.bytes <= len(b) {
+		t.Error("unexpected bytes", tm.bytes)
+	}
+	assertEqual(t, 1, tm.poolWait)
+}
+
+func TestMetricsFormat(t *testing.T) {
+	for format, expected := range map[string]string{
+		"png":       "png",
+		"png8:m=h":  "png",
+		"PNG32":     "png",
+		"jpeg85":    "jpeg",
+		"webp:q=90": "webp",
+		"tiff:t=1":  "tiff",
+		"tif":       "tiff",
+		"raw":       "raw",
+		"image":     "image",
+		"multi":     "multi",
+		"composite": "composite",
+	} {
+		assertEqual(t, expected, metricsFormat(format))
+	}
+	assertEqual(t, "", baseFormat("invalid"))
+}
+
+func TestSetMetricsConcurrent(t *testing.T) {
+	defer SetMetrics(nil)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			SetMetrics(&testMetrics{})
+			SetMetrics(nil)
+		}()
+		go func() {
+			defer wg.Done()
+			ObservePoolWait(time.Millisecond)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExpvarMetrics(t *testing.T) {
+	e := NewExpvarMetrics("mapnik_test")
+	e.Render("png256", 20*time.Millisecond)
+	e.Render("png256", 2*time.Second)
+	e.Encode("png256", time.Millisecond, 1000)
+	e.Error(ErrorRender)
+	e.PoolWait(time.Millisecond)
+
+	var result struct {
+		Renders       map[string]int `json:"renders"`
+		RenderSeconds map[string]struct {
+			Count   int                `json:"count"`
+			Sum     float64            `json:"sum"`
+			Buckets map[string]float64 `json:"buckets"`
+		} `json:"render_seconds"`
+		Bytes  map[string]int `json:"bytes"`
+		Errors map[string]int `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("mapnik_test").String()), &result); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, map[string]int{"png256": 2}, result.Renders)
+	h := result.RenderSeconds["png256"]
+	assertEqual(t, 2, h.Count)
+	if math.Abs(h.Sum-2.02) > 1e-9 {
+		t.Error("unexpected sum", h.Sum)
+	}
+	assertEqual(t, 0.0, h.Buckets["0.01"])
+	assertEqual(t, 1.0, h.Buckets["0.025"])
+	assertEqual(t, 1.0, h.Buckets["1"])
+	assertEqual(t, 2.0, h.Buckets["2.5"])
+	assertEqual(t, 2.0, h.Buckets["+Inf"])
+	assertEqual(t, map[string]int{"png256": 1000}, result.Bytes)
+	assertEqual(t, map[string]int{"render": 1}, result.Errors)
+}
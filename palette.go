@@ -0,0 +1,185 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// ErrPaletteFreed is returned by renderings with a Palette after Free.
+var ErrPaletteFreed = errors.New("mapnik: palette already freed")
+
+// Palette is a fixed color palette for paletted PNG output. Use the same
+// Palette for all tiles to get consistent color indices. A Palette is safe
+// for concurrent use, but renderings with the same Palette are serialized
+// while they map colors to the palette, as Mapnik caches the mapped colors
+// in the palette. Use a Palette for each goroutine to avoid this. Call Free
+// to release the palette. Palettes that are not freed are released by the
+// garbage collector.
+type Palette struct {
+	mu sync.Mutex
+	p  *C.struct__mapnik_palette_t
+}
+
+func newPaletteHandle(p *C.struct__mapnik_palette_t) *Palette {
+	pal := &Palette{p: p}
+	runtime.SetFinalizer(pal, (*Palette).Free)
+	return pal
+}
+
+// LoadPalette reads a palette file in RGBA (four bytes for each color), RGB
+// (.rgb suffix, three bytes for each color) or Adobe Color Table (.act
+// suffix) format.
+func LoadPalette(path string) (*Palette, error) {
+	p, err := loadPalette(path)
+	if err != nil {
+		return nil, err
+	}
+	return newPaletteHandle(p), nil
+}
+
+// NewPalette creates a Palette from up to 256 colors.
+func NewPalette(colors color.Palette) (*Palette, error) {
+	if len(colors) == 0 || len(colors) > 256 {
+		return nil, fmt.Errorf("mapnik: invalid number of palette colors %d, expected 1-256", len(colors))
+	}
+	data := make([]byte, 0, len(colors)*4)
+	for _, c := range colors {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		data = append(data, nc.R, nc.G, nc.B, nc.A)
+	}
+	p, err := newPalette(data, C.MAPNIK_PALETTE_RGBA)
+	if err != nil {
+		return nil, err
+	}
+	return newPaletteHandle(p), nil
+}
+
+// Free deallocates the palette. Free can be called multiple times.
+func (p *Palette) Free() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.p == nil {
+		return
+	}
+	C.mapnik_palette_free(p.p)
+	p.p = nil
+	runtime.SetFinalizer(p, nil)
+}
+
+func (p *Palette) freed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.p == nil
+}
+
+// Colors returns the colors of the palette in the order of the color
+// indices of paletted images. The order can differ from the order of the
+// palette file or the colors passed to NewPalette, as Mapnik sorts
+// transparent colors first. Returns nil after Free.
+func (p *Palette) Colors() color.Palette {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.colors()
+}
+
+func (p *Palette) colors() color.Palette {
+	if p.p == nil {
+		return nil
+	}
+	rgba := make([]byte, 256*4)
+	n := int(C.mapnik_palette_colors(p.p, (*C.uint8_t)(unsafe.Pointer(&rgba[0]))))
+	colors := make(color.Palette, n)
+	for i := 0; i < n; i++ {
+		colors[i] = color.NRGBA{rgba[i*4], rgba[i*4+1], rgba[i*4+2], rgba[i*4+3]}
+	}
+	return colors
+}
+
+// Paletted returns a copy of the image with all colors mapped to the palette.
+// Returns nil if the image or the palette was freed.
+func (i *Image) Paletted(p *Palette) *image.Paletted {
+	if i.i == nil {
+		return nil
+	}
+	defer runtime.KeepAlive(i)
+	img, err := imageToPaletted(i.i, p)
+	if err != nil {
+		return nil
+	}
+	return img
+}
+
+// RenderPaletted returns the map as an unencoded image.Paletted with all
+// colors mapped to the palette.
+func (m *Map) RenderPaletted(opts RenderOpts, p *Palette) (*image.Paletted, error) {
+	if m.m == nil {
+		return nil, ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	i, err := m.renderImage(opts, "image")
+	if err != nil {
+		return nil, err
+	}
+	defer C.mapnik_image_free(i)
+	return imageToPaletted(i, p)
+}
+
+func imageToPaletted(i *C.struct__mapnik_image_t, p *Palette) (*image.Paletted, error) {
+	// quantize updates the color cache of the palette
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.p == nil {
+		return nil, ErrPaletteFreed
+	}
+	width := int(C.mapnik_image_width(i))
+	height := int(C.mapnik_image_height(i))
+	img := image.NewPaletted(image.Rect(0, 0, width, height), p.colors())
+	if len(img.Pix) > 0 {
+		C.mapnik_image_quantize(i, p.p, (*C.uint8_t)(unsafe.Pointer(&img.Pix[0])))
+	}
+	return img, nil
+}
+
+func loadPalette(path string) (*C.struct__mapnik_palette_t, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("mapnik: reading palette: %w", err)
+	}
+	typ := C.MAPNIK_PALETTE_RGBA
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".act":
+		typ = C.MAPNIK_PALETTE_ACT
+	case ".rgb":
+		typ = C.MAPNIK_PALETTE_RGB
+	}
+	p, err := newPalette(data, typ)
+	if err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, path)
+	}
+	return p, nil
+}
+
+func newPalette(data []byte, typ C.int) (*C.struct__mapnik_palette_t, error) {
+	if len(data) == 0 {
+		return nil, errors.New("mapnik: empty palette")
+	}
+	p := C.mapnik_palette((*C.char)(unsafe.Pointer(&data[0])), C.size_t(len(data)), typ)
+	if e := C.mapnik_palette_last_error(p); e != nil {
+		err := errors.New("mapnik: loading palette: " + C.GoString(e))
+		C.mapnik_palette_free(p)
+		return nil, err
+	}
+	return p, nil
+}
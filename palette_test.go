@@ -0,0 +1,201 @@
+package mapnik
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var testPalette = color.Palette{
+	color.NRGBA{0, 0, 0, 0},
+	color.NRGBA{255, 0, 0, 255},
+	color.NRGBA{0, 255, 0, 255},
+	color.NRGBA{0, 0, 255, 255},
+	color.NRGBA{70, 130, 180, 255}, // steelblue
+}
+
+func TestNewPalette(t *testing.T) {
+	p, err := NewPalette(testPalette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Free()
+
+	colors := p.Colors()
+	if len(colors) != len(testPalette) {
+		t.Fatal("unexpected palette size", len(colors))
+	}
+	for _, c := range testPalette {
+		if !paletteContains(colors, c) {
+			t.Error("color missing in palette", c)
+		}
+	}
+
+	if _, err := NewPalette(nil); err == nil {
+		t.Error("empty palette did not return an error")
+	}
+	if _, err := NewPalette(make(color.Palette, 257)); err == nil {
+		t.Error("large palette did not return an error")
+	}
+}
+
+func TestLoadPalette(t *testing.T) {
+	out, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal("unable to create temp dir")
+	}
+	defer os.RemoveAll(out)
+
+	fname := filepath.Join(out, "palette.rgb")
+	if err := os.WriteFile(fname, []byte{255, 0, 0, 0, 255, 0}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := LoadPalette(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Free()
+	if n := len(p.Colors()); n != 2 {
+		t.Error("unexpected palette size", n)
+	}
+
+	if _, err := LoadPalette(filepath.Join(out, "missing.act")); err == nil {
+		t.Error("missing palette did not return an error")
+	}
+}
+
+func TestRenderPaletted(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	p, err := NewPalette(testPalette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Free()
+
+	img, err := m.RenderPaletted(RenderOpts{}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, image.Rect(0, 0, 800, 600), img.Bounds())
+	assertEqual(t, p.Colors(), img.Palette)
+	assertEqual(t, color.NRGBA{70, 130, 180, 255}, img.At(0, 0))
+}
+
+func TestRenderWithPalette(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	p, err := NewPalette(testPalette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Free()
+
+	opts := RenderOpts{Encoding: PNGOptions{Paletted: true, Palette: p}}
+	// render twice to check that the palette is identical
+	for i := 0; i < 2; i++ {
+		b, err := m.Render(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := png.Decode(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		paletted, ok := img.(*image.Paletted)
+		if !ok {
+			t.Fatalf("expected paletted image, got %T", img)
+		}
+		for _, c := range paletted.Palette {
+			if !paletteContains(testPalette, color.NRGBAModel.Convert(c)) {
+				t.Error("unexpected color in palette", c)
+			}
+		}
+		m.ZoomTo(0, 0, 10, 10)
+	}
+
+	if _, err := m.Render(RenderOpts{Encoding: PNGOptions{Palette: p}}); err == nil {
+		t.Error("palette without paletted option did not return an error")
+	}
+}
+
+func TestPaletteConcurrent(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	p, err := NewPalette(testPalette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Free()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 4; i++ {
+		c := m.Clone()
+		defer c.Free()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				if _, err := c.RenderPaletted(RenderOpts{}, p); err != nil {
+					t.Error(err)
+				}
+				if _, err := c.Render(RenderOpts{Encoding: PNGOptions{Paletted: true, Palette: p}}); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestPaletteFree(t *testing.T) {
+	m := New()
+	defer m.Free()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	p, err := NewPalette(testPalette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Free()
+	p.Free()
+	if p.Colors() != nil {
+		t.Error("expected no colors after Free")
+	}
+	if _, err := m.RenderPaletted(RenderOpts{}, p); err != ErrPaletteFreed {
+		t.Error("expected ErrPaletteFreed, got", err)
+	}
+	if _, err := m.Render(RenderOpts{Encoding: PNGOptions{Paletted: true, Palette: p}}); err != ErrPaletteFreed {
+		t.Error("expected ErrPaletteFreed, got", err)
+	}
+}
+
+func paletteContains(p color.Palette, c color.Color) bool {
+	for _, pc := range p {
+		if pc == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Feature is a single feature of a layer datasource.
+type Feature struct {
+	ID         int64
+	Attributes map[string]string
+}
+
+// QueryPoint returns all features of the layer at the pixel position x, y.
+// Call after Resize and ZoomAll/ZoomTo.
+func (m *Map) QueryPoint(layer string, x, y float64) ([]Feature, error) {
+	if m.m == nil {
+		return nil, ErrMapFreed
+	}
+	defer runtime.KeepAlive(m)
+	idx, ok := m.layerIndex(layer)
+	if !ok {
+		return nil, errors.New("mapnik: unknown layer " + layer)
+	}
+	fs := C.mapnik_map_query_point(m.m, C.size_t(idx), C.double(x), C.double(y))
+	if fs == nil {
+		return nil, m.lastError()
+	}
+	defer C.mapnik_featureset_free(fs)
+
+	n := int(C.mapnik_featureset_count(fs))
+	features := make([]Feature, n)
+	for i := 0; i < n; i++ {
+		f := &features[i]
+		f.ID = int64(C.mapnik_featureset_feature_id(fs, C.size_t(i)))
+		attrs := int(C.mapnik_featureset_attr_count(fs, C.size_t(i)))
+		f.Attributes = make(map[string]string, attrs)
+		for a := 0; a < attrs; a++ {
+			name := C.GoString(C.mapnik_featureset_attr_name(fs, C.size_t(i), C.size_t(a)))
+			f.Attributes[name] = C.GoString(C.mapnik_featureset_attr_value(fs, C.size_t(i), C.size_t(a)))
+		}
+	}
+	return features, nil
+}
@@ -0,0 +1,161 @@
+package seed
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+// MBTiles writes tiles into an MBTiles 1.3 SQLite file. MBTiles is safe
+// for concurrent use.
+//
+// MBTiles uses database/sql with the "sqlite3" driver. Import a driver
+// like github.com/mattn/go-sqlite3 to register it.
+type MBTiles struct {
+	mu sync.Mutex
+	db *sql.DB
+	tx *sql.Tx
+}
+
+type execQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// conn returns the current transaction or the database. Call with mu locked.
+func (m *MBTiles) conn() execQuerier {
+	if m.tx != nil {
+		return m.tx
+	}
+	return m.db
+}
+
+const mbtilesSchema = `
+CREATE TABLE IF NOT EXISTS metadata (name TEXT PRIMARY KEY, value TEXT);
+CREATE TABLE IF NOT EXISTS tiles (
+	zoom_level INTEGER,
+	tile_column INTEGER,
+	tile_row INTEGER,
+	tile_data BLOB,
+	PRIMARY KEY (zoom_level, tile_column, tile_row)
+);
+`
+
+// OpenMBTiles opens or creates an MBTiles file. Existing tiles are kept.
+func OpenMBTiles(path string) (*MBTiles, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only supports a single writer
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(mbtilesSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating MBTiles schema: %w", err)
+	}
+	return &MBTiles{db: db}, nil
+}
+
+// SetMetadata stores a metadata value (name, format, bounds, etc.).
+func (m *MBTiles) SetMetadata(name, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.conn().Exec("INSERT OR REPLACE INTO metadata (name, value) VALUES (?, ?)", name, value)
+	return err
+}
+
+// Metadata returns a metadata value or an empty string.
+func (m *MBTiles) Metadata(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var value string
+	err := m.conn().QueryRow("SELECT value FROM metadata WHERE name = ?", name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// tmsRow converts the XYZ y to the TMS row of MBTiles.
+func tmsRow(z, y int) int {
+	return (1 << z) - 1 - y
+}
+
+// HasTile returns whether the XYZ tile exists.
+func (m *MBTiles) HasTile(z, x, y int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int
+	err := m.conn().QueryRow(
+		"SELECT COUNT(*) FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		z, x, tmsRow(z, y),
+	).Scan(&n)
+	return n > 0, err
+}
+
+// Tile returns the data of the XYZ tile or nil if it does not exist.
+func (m *MBTiles) Tile(z, x, y int) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var data []byte
+	err := m.conn().QueryRow(
+		"SELECT tile_data FROM tiles WHERE zoom_level = ? AND tile_column = ? AND tile_row = ?",
+		z, x, tmsRow(z, y),
+	).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return data, err
+}
+
+// PutTile stores the XYZ tile. Tiles are stored in the current transaction
+// if Begin was called.
+func (m *MBTiles) PutTile(z, x, y int, data []byte) error {
+	const stmt = "INSERT OR REPLACE INTO tiles (zoom_level, tile_column, tile_row, tile_data) VALUES (?, ?, ?, ?)"
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.conn().Exec(stmt, z, x, tmsRow(z, y), data)
+	return err
+}
+
+// Begin starts a transaction for the following PutTile calls.
+func (m *MBTiles) Begin() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.tx != nil {
+		return nil
+	}
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	m.tx = tx
+	return nil
+}
+
+// Commit commits the current transaction.
+func (m *MBTiles) Commit() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.commit()
+}
+
+func (m *MBTiles) commit() error {
+	if m.tx == nil {
+		return nil
+	}
+	err := m.tx.Commit()
+	m.tx = nil
+	return err
+}
+
+// Close commits pending tiles and closes the file.
+func (m *MBTiles) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.commit(); err != nil {
+		m.db.Close()
+		return err
+	}
+	return m.db.Close()
+}
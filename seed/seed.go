@@ -0,0 +1,363 @@
+// Package seed pre-renders XYZ tiles of a Mapnik stylesheet into MBTiles
+// files.
+//
+// Tiles are rendered in the Web Mercator projection by a pool of workers,
+// each with its own mapnik.Map. Seeding is resumable: tiles that already
+// exist in the MBTiles file are skipped.
+package seed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"runtime"
+	"strconv"
+	"sync"
+
+	mapnik "github.com/omniscale/go-mapnik/v3"
+)
+
+// Formats are the supported tile formats with their Mapnik format.
+var Formats = map[string]string{
+	"png":  "png256",
+	"jpg":  "jpeg85",
+	"webp": "webp",
+}
+
+// Options for Seed.
+type Options struct {
+	// Stylesheet is the Mapnik XML that is loaded for each worker.
+	Stylesheet string
+	// BBox in EPSG:4326 as minlon, minlat, maxlon, maxlat.
+	BBox [4]float64
+	// MinZoom and MaxZoom of the seeded tiles (inclusive).
+	MinZoom int
+	MaxZoom int
+	// Format of the tiles (png, jpg or webp). Defaults to png.
+	Format string
+	// TileSize in pixel. Defaults to 256.
+	TileSize int
+	// MetaTileSize renders MetaTileSize x MetaTileSize tiles at once and
+	// splits the result. This reduces the number of cut labels at tile
+	// borders. Defaults to 1 (no metatiles).
+	MetaTileSize int
+	// BufferSize in pixel around each (meta)tile for Map.SetBufferSize.
+	BufferSize int
+	// Workers is the number of concurrent renderers. Defaults to the
+	// number of CPUs.
+	Workers int
+	// Name, Description and Attribution for the MBTiles metadata.
+	Name        string
+	Description string
+	Attribution string
+	// Progress is called after each (meta)tile with the number of done and
+	// total (meta)tiles, including skipped ones.
+	Progress func(done, total int)
+}
+
+func (o *Options) setDefaults() error {
+	if o.Format == "" {
+		o.Format = "png"
+	}
+	if _, ok := Formats[o.Format]; !ok {
+		return fmt.Errorf("unsupported format %q", o.Format)
+	}
+	if o.TileSize <= 0 {
+		o.TileSize = 256
+	}
+	if o.MetaTileSize <= 0 {
+		o.MetaTileSize = 1
+	}
+	if o.Workers <= 0 {
+		o.Workers = runtime.NumCPU()
+	}
+	if o.MinZoom < 0 || o.MaxZoom > 30 || o.MinZoom > o.MaxZoom {
+		return fmt.Errorf("invalid zoom range %d-%d", o.MinZoom, o.MaxZoom)
+	}
+	if o.BBox[0] >= o.BBox[2] || o.BBox[1] >= o.BBox[3] {
+		return fmt.Errorf("invalid bbox %v", o.BBox)
+	}
+	if o.Name == "" {
+		o.Name = o.Stylesheet
+	}
+	return nil
+}
+
+// tileRange returns the XYZ tiles (minx, miny, maxx, maxy inclusive) that
+// intersect the EPSG:4326 bbox at zoom level z.
+func tileRange(bbox [4]float64, z int) [4]int {
+	n := 1 << z
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		}
+		if v >= n {
+			return n - 1
+		}
+		return v
+	}
+	tileX := func(lon float64) int {
+		return clamp(int(math.Floor((lon + 180) / 360 * float64(n))))
+	}
+	tileY := func(lat float64) int {
+		lat = math.Max(-85.0511287798, math.Min(85.0511287798, lat))
+		rad := lat * math.Pi / 180
+		return clamp(int(math.Floor((1 - math.Log(math.Tan(rad)+1/math.Cos(rad))/math.Pi) / 2 * float64(n))))
+	}
+	return [4]int{tileX(bbox[0]), tileY(bbox[3]), tileX(bbox[2]), tileY(bbox[1])}
+}
+
+// metaTile is a block of tiles that is rendered at once. Only the tiles
+// within the seeding range are stored.
+type metaTile struct {
+	z, x, y int
+	w, h    int
+	rng     [4]int
+}
+
+// tiles returns the XYZ coordinates of all tiles within the seeding range.
+func (mt metaTile) tiles() [][2]int {
+	var tiles [][2]int
+	for y := mt.y; y < mt.y+mt.h; y++ {
+		for x := mt.x; x < mt.x+mt.w; x++ {
+			if x >= mt.rng[0] && x <= mt.rng[2] && y >= mt.rng[1] && y <= mt.rng[3] {
+				tiles = append(tiles, [2]int{x, y})
+			}
+		}
+	}
+	return tiles
+}
+
+// bbox returns the Web Mercator extent of the metatile.
+func (mt metaTile) bbox() [4]float64 {
+	const origin = 20037508.342789244
+	res := 2 * origin / math.Exp2(float64(mt.z))
+	return [4]float64{
+		-origin + float64(mt.x)*res,
+		origin - float64(mt.y+mt.h)*res,
+		-origin + float64(mt.x+mt.w)*res,
+		origin - float64(mt.y)*res,
+	}
+}
+
+// metaTiles returns all metatiles for the EPSG:4326 bbox and zoom levels.
+// Metatiles are aligned to multiples of size.
+func metaTiles(bbox [4]float64, minZoom, maxZoom, size int) []metaTile {
+	var result []metaTile
+	for z := minZoom; z <= maxZoom; z++ {
+		n := 1 << z
+		rng := tileRange(bbox, z)
+		for y := rng[1] / size * size; y <= rng[3]; y += size {
+			for x := rng[0] / size * size; x <= rng[2]; x += size {
+				mt := metaTile{z: z, x: x, y: y, w: size, h: size, rng: rng}
+				if mt.x+mt.w > n {
+					mt.w = n - mt.x
+				}
+				if mt.y+mt.h > n {
+					mt.h = n - mt.y
+				}
+				result = append(result, mt)
+			}
+		}
+	}
+	return result
+}
+
+type tile struct {
+	z, x, y int
+	data    []byte
+}
+
+// Seed renders all tiles for the options into the MBTiles file. Tiles that
+// already exist are skipped, so an interrupted Seed can be resumed by
+// calling Seed with the same options.
+func Seed(ctx context.Context, mb *MBTiles, opts Options) error {
+	if err := opts.setDefaults(); err != nil {
+		return err
+	}
+	for name, value := range map[string]string{
+		"name":        opts.Name,
+		"description": opts.Description,
+		"attribution": opts.Attribution,
+		"format":      opts.Format,
+		"type":        "baselayer",
+		"version":     "1.0.0",
+		"minzoom":     strconv.Itoa(opts.MinZoom),
+		"maxzoom":     strconv.Itoa(opts.MaxZoom),
+		"bounds":      fmt.Sprintf("%g,%g,%g,%g", opts.BBox[0], opts.BBox[1], opts.BBox[2], opts.BBox[3]),
+	} {
+		if err := mb.SetMetadata(name, value); err != nil {
+			return err
+		}
+	}
+
+	maps := make([]*mapnik.Map, opts.Workers)
+	for i := range maps {
+		m := mapnik.New()
+		if err := m.Load(opts.Stylesheet); err != nil {
+			m.Free()
+			for _, m := range maps[:i] {
+				m.Free()
+			}
+			return err
+		}
+		m.SetSRS("epsg:3857")
+		m.SetBufferSize(opts.BufferSize)
+		maps[i] = m
+	}
+	defer func() {
+		for _, m := range maps {
+			m.Free()
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	mts := metaTiles(opts.BBox, opts.MinZoom, opts.MaxZoom, opts.MetaTileSize)
+	jobs := make(chan metaTile)
+	results := make(chan []tile)
+	errc := make(chan error, opts.Workers+1)
+
+	// results is closed after the dispatcher and all workers are done
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(jobs)
+		for _, mt := range mts {
+			exists, err := hasTiles(mb, mt)
+			if err != nil {
+				errc <- err
+				cancel()
+				return
+			}
+			if exists {
+				// report skipped metatiles as empty results for progress
+				select {
+				case results <- nil:
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			select {
+			case jobs <- mt:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for _, m := range maps {
+		wg.Add(1)
+		go func(m *mapnik.Map) {
+			defer wg.Done()
+			for mt := range jobs {
+				tiles, err := renderMetaTile(m, mt, opts)
+				if err != nil {
+					errc <- err
+					cancel()
+					return
+				}
+				select {
+				case results <- tiles:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(m)
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	done := 0
+	var err error
+	if err = mb.Begin(); err != nil {
+		cancel()
+	}
+	for tiles := range results {
+		if err != nil {
+			continue // drain results after errors
+		}
+		for _, t := range tiles {
+			if err = mb.PutTile(t.z, t.x, t.y, t.data); err != nil {
+				cancel()
+				break
+			}
+		}
+		done++
+		if done%100 == 0 && err == nil {
+			if err = mb.Commit(); err == nil {
+				err = mb.Begin()
+			}
+			if err != nil {
+				cancel()
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(done, len(mts))
+		}
+	}
+	if cerr := mb.Commit(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-errc:
+		return err
+	default:
+	}
+	return ctx.Err()
+}
+
+func hasTiles(mb *MBTiles, mt metaTile) (bool, error) {
+	for _, t := range mt.tiles() {
+		ok, err := mb.HasTile(mt.z, t[0], t[1])
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func renderMetaTile(m *mapnik.Map, mt metaTile, opts Options) ([]tile, error) {
+	format := Formats[opts.Format]
+	m.Resize(mt.w*opts.TileSize, mt.h*opts.TileSize)
+	bbox := mt.bbox()
+	m.ZoomTo(bbox[0], bbox[1], bbox[2], bbox[3])
+
+	if mt.w == 1 && mt.h == 1 {
+		b, err := m.Render(mapnik.RenderOpts{Format: format})
+		if err != nil {
+			return nil, err
+		}
+		return []tile{{z: mt.z, x: mt.x, y: mt.y, data: b}}, nil
+	}
+
+	img, err := m.RenderImage(mapnik.RenderOpts{})
+	if err != nil {
+		return nil, err
+	}
+	var tiles []tile
+	for _, t := range mt.tiles() {
+		x0 := (t[0] - mt.x) * opts.TileSize
+		y0 := (t[1] - mt.y) * opts.TileSize
+		sub := img.SubImage(image.Rect(x0, y0, x0+opts.TileSize, y0+opts.TileSize))
+		b, err := mapnik.Encode(sub, format)
+		if err != nil {
+			return nil, err
+		}
+		tiles = append(tiles, tile{z: mt.z, x: t[0], y: t[1], data: b})
+	}
+	if len(tiles) == 0 {
+		return nil, errors.New("empty metatile")
+	}
+	return tiles, nil
+}
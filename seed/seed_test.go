@@ -0,0 +1,163 @@
+package seed
+
+import (
+	"bytes"
+	"context"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTileRange(t *testing.T) {
+	world := [4]float64{-180, -90, 180, 90}
+	for _, tc := range []struct {
+		bbox [4]float64
+		z    int
+		rng  [4]int
+	}{
+		{world, 0, [4]int{0, 0, 0, 0}},
+		{world, 2, [4]int{0, 0, 3, 3}},
+		{[4]float64{0.1, 0.1, 10, 10}, 1, [4]int{1, 0, 1, 0}},
+		{[4]float64{4, 49, 12, 54}, 8, [4]int{130, 82, 136, 87}},
+	} {
+		if rng := tileRange(tc.bbox, tc.z); rng != tc.rng {
+			t.Errorf("unexpected range for %v/%d: %v", tc.bbox, tc.z, rng)
+		}
+	}
+}
+
+func TestMetaTiles(t *testing.T) {
+	mts := metaTiles([4]float64{-180, -90, 180, 90}, 0, 2, 4)
+	if len(mts) != 3 {
+		t.Fatal("unexpected number of metatiles", len(mts))
+	}
+	// metatiles are clipped to the tile matrix
+	if mts[1].w != 2 || mts[1].h != 2 {
+		t.Error("unexpected metatile size", mts[1])
+	}
+	if n := len(mts[2].tiles()); n != 16 {
+		t.Error("unexpected number of tiles", n)
+	}
+
+	mts = metaTiles([4]float64{0.1, 0.1, 10, 10}, 3, 3, 2)
+	if len(mts) != 1 {
+		t.Fatal("unexpected number of metatiles", len(mts))
+	}
+	if tiles := mts[0].tiles(); !reflect.DeepEqual(tiles, [][2]int{{4, 3}}) {
+		t.Error("unexpected tiles", tiles)
+	}
+}
+
+func TestMBTiles(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mb, err := OpenMBTiles(filepath.Join(dir, "test.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mb.Close()
+
+	if err := mb.SetMetadata("name", "test"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mb.Metadata("name"); err != nil || v != "test" {
+		t.Error("unexpected metadata", v, err)
+	}
+	if err := mb.Begin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mb.PutTile(1, 0, 0, []byte("tile")); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := mb.HasTile(1, 0, 0); err != nil || !ok {
+		t.Error("tile not found", err)
+	}
+	if err := mb.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := mb.HasTile(1, 0, 1); ok {
+		t.Error("unexpected tile")
+	}
+	// stored as TMS row
+	var row int
+	if err := mb.db.QueryRow("SELECT tile_row FROM tiles").Scan(&row); err != nil || row != 1 {
+		t.Error("unexpected tile row", row, err)
+	}
+}
+
+func TestSeed(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mb, err := OpenMBTiles(filepath.Join(dir, "test.mbtiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mb.Close()
+
+	rendered := 0
+	opts := Options{
+		Stylesheet:   "../test/map.xml",
+		BBox:         [4]float64{4, 49, 12, 54},
+		MinZoom:      0,
+		MaxZoom:      5,
+		MetaTileSize: 2,
+		Workers:      2,
+		Progress:     func(done, total int) { rendered = done },
+	}
+	if err := Seed(context.Background(), mb, opts); err != nil {
+		t.Fatal(err)
+	}
+	if rendered == 0 {
+		t.Error("progress not reported")
+	}
+
+	var n int
+	if err := mb.db.QueryRow("SELECT COUNT(*) FROM tiles").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	expected := 0
+	for z := 0; z <= 5; z++ {
+		r := tileRange(opts.BBox, z)
+		expected += (r[2] - r[0] + 1) * (r[3] - r[1] + 1)
+	}
+	if n != expected {
+		t.Errorf("unexpected number of tiles %d, expected %d", n, expected)
+	}
+
+	data, err := mb.Tile(5, 17, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds() != image.Rect(0, 0, 256, 256) {
+		t.Error("unexpected tile size", img.Bounds())
+	}
+
+	// resume does not render existing tiles
+	if err := mb.PutTile(5, 17, 10, []byte("existing")); err != nil {
+		t.Fatal(err)
+	}
+	opts.MetaTileSize = 1
+	if err := Seed(context.Background(), mb, opts); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := mb.Tile(5, 17, 10); string(data) != "existing" {
+		t.Error("existing tile was rendered again")
+	}
+}
@@ -0,0 +1,80 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"time"
+)
+
+// LayerStatus describes if a layer was rendered or why it was skipped.
+type LayerStatus int
+
+var (
+	// LayerRendered is set for layers that were queried and rendered.
+	LayerRendered = LayerStatus(C.MAPNIK_LAYER_RENDERED)
+	// LayerSkippedScale is set for layers (or all rules of their styles)
+	// that are not visible at the scale denominator of the map.
+	LayerSkippedScale = LayerStatus(C.MAPNIK_LAYER_SKIPPED_SCALE)
+	// LayerSkippedExtent is set for layers that are outside of the map
+	// extent.
+	LayerSkippedExtent = LayerStatus(C.MAPNIK_LAYER_SKIPPED_EXTENT)
+)
+
+func (s LayerStatus) String() string {
+	switch s {
+	case LayerRendered:
+		return "rendered"
+	case LayerSkippedScale:
+		return "skipped (scale)"
+	case LayerSkippedExtent:
+		return "skipped (extent)"
+	}
+	return "unknown"
+}
+
+// LayerStats contains the statistics of a single layer.
+type LayerStats struct {
+	Name   string
+	Status LayerStatus
+	// Duration is the wall time for querying the datasource and rendering
+	// the features of the layer.
+	Duration time.Duration
+	// FeaturesFetched is the number of features returned by the datasource.
+	// Features are counted for each style if the layer has multiple styles
+	// and does not cache features.
+	FeaturesFetched int
+	// FeaturesRendered is the number of fetched features that match at
+	// least one rule of the layer styles.
+	FeaturesRendered int
+}
+
+// RenderStats contains the statistics of a single rendering. See
+// RenderOpts.CollectStats.
+type RenderStats struct {
+	// Duration is the wall time of the rendering, without encoding.
+	Duration time.Duration
+	// Layers contains the stats of all active layers in rendering order.
+	Layers []LayerStats
+}
+
+func renderStats(s *C.struct__mapnik_render_stats_t) RenderStats {
+	stats := RenderStats{
+		Duration: seconds(C.mapnik_render_stats_seconds(s)),
+		Layers:   make([]LayerStats, int(C.mapnik_render_stats_layer_count(s))),
+	}
+	for i := range stats.Layers {
+		l := &stats.Layers[i]
+		l.Name = C.GoString(C.mapnik_render_stats_layer_name(s, C.size_t(i)))
+		l.Status = LayerStatus(C.mapnik_render_stats_layer_status(s, C.size_t(i)))
+		l.Duration = seconds(C.mapnik_render_stats_layer_seconds(s, C.size_t(i)))
+		l.FeaturesFetched = int(C.mapnik_render_stats_layer_fetched(s, C.size_t(i)))
+		l.FeaturesRendered = int(C.mapnik_render_stats_layer_rendered(s, C.size_t(i)))
+	}
+	return stats
+}
+
+func seconds(s C.double) time.Duration {
+	return time.Duration(float64(s) * float64(time.Second))
+}
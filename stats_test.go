@@ -0,0 +1,61 @@
+package mapnik
+
+import (
+	"testing"
+)
+
+func TestRenderCollectStats(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	stats := RenderStats{}
+	if _, err := m.Render(RenderOpts{Format: "png", CollectStats: &stats}); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Duration <= 0 {
+		t.Error("unexpected duration", stats.Duration)
+	}
+	// layerD is not active
+	if len(stats.Layers) != 3 {
+		t.Fatal("unexpected layer stats", stats.Layers)
+	}
+	for i, name := range []string{"layerA", "layerB", "layerC"} {
+		l := stats.Layers[i]
+		assertEqual(t, name, l.Name)
+		assertEqual(t, LayerRendered, l.Status)
+		assertEqual(t, 1, l.FeaturesFetched)
+		assertEqual(t, 1, l.FeaturesRendered)
+	}
+
+	// features are outside of the map extent
+	m.ZoomTo(-170, -80, -160, -70)
+	if _, err := m.RenderImage(RenderOpts{CollectStats: &stats}); err != nil {
+		t.Fatal(err)
+	}
+	if len(stats.Layers) != 3 {
+		t.Fatal("unexpected layer stats", stats.Layers)
+	}
+	for _, l := range stats.Layers {
+		assertEqual(t, 0, l.FeaturesRendered)
+	}
+}
+
+func TestRenderCollectStatsRestoresDatasources(t *testing.T) {
+	m := New()
+	if err := m.Load("test/map.xml"); err != nil {
+		t.Fatal(err)
+	}
+	m.ZoomAll()
+
+	if _, err := m.RenderImage(RenderOpts{CollectStats: &RenderStats{}}); err != nil {
+		t.Fatal(err)
+	}
+	features, err := m.QueryPoint("layerA", 417, 185)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, 1, len(features))
+}
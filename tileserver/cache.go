@@ -0,0 +1,92 @@
+package tileserver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrCacheMiss is returned by TileCache.Get for tiles that are not cached.
+var ErrCacheMiss = errors.New("tile not cached")
+
+// Tile is an encoded tile image.
+type Tile struct {
+	Data    []byte
+	ModTime time.Time
+}
+
+// TileCache stores rendered tiles. The Handler calls Get before rendering a
+// tile and Put after rendering. Implementations need to be safe for
+// concurrent use.
+type TileCache interface {
+	// Get returns the cached tile or ErrCacheMiss.
+	Get(k TileKey) (*Tile, error)
+	// Put stores the tile.
+	Put(k TileKey, t *Tile) error
+}
+
+// FileCache stores tiles in the file system (see TileKey.Path).
+type FileCache struct {
+	Dir string
+}
+
+// NewFileCache returns a FileCache for the directory.
+func NewFileCache(dir string) *FileCache {
+	return &FileCache{Dir: dir}
+}
+
+func (c *FileCache) path(k TileKey) string {
+	return filepath.Join(c.Dir, filepath.FromSlash(k.Path()))
+}
+
+func (c *FileCache) Get(k TileKey) (*Tile, error) {
+	fname := c.path(k)
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrCacheMiss
+		}
+		return nil, err
+	}
+	fi, err := os.Stat(fname)
+	if err != nil {
+		return nil, err
+	}
+	return &Tile{Data: data, ModTime: fi.ModTime()}, nil
+}
+
+// Put writes the tile to a temporary file and renames it, so that
+// concurrent Gets never read partial tiles.
+func (c *FileCache) Put(k TileKey, t *Tile) error {
+	fname := c.path(k)
+	if err := os.MkdirAll(filepath.Dir(fname), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(fname), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if _, err := f.Write(t.Data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if !t.ModTime.IsZero() {
+		os.Chtimes(f.Name(), t.ModTime, t.ModTime)
+	}
+	if err := os.Rename(f.Name(), fname); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
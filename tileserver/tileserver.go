@@ -0,0 +1,329 @@
+// Package tileserver implements an http.Handler for XYZ tiles in the
+// Web Mercator projection (/{z}/{x}/{y}.{format}) on top of mapnik.Map.
+//
+// Tiles with an @2x suffix (/{z}/{x}/{y}@2x.{format}) are rendered with
+// twice the size and a ScaleFactor of 2 for high resolution displays.
+// Layers can be selected for each request with the layers query parameter
+// (e.g. ?layers=roads,labels). Rendered tiles are stored in an optional
+// TileCache.
+package tileserver
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mapnik "github.com/omniscale/go-mapnik/v3"
+)
+
+// DefaultFormats are the supported file extensions with their Mapnik format.
+var DefaultFormats = map[string]string{
+	"png":  "png256",
+	"jpg":  "jpeg85",
+	"jpeg": "jpeg85",
+	"webp": "webp",
+}
+
+var contentTypes = map[string]string{
+	"png":  "image/png",
+	"jpg":  "image/jpeg",
+	"jpeg": "image/jpeg",
+	"webp": "image/webp",
+}
+
+// Config for the tile server Handler.
+type Config struct {
+	// Stylesheet is the Mapnik XML that is loaded for each map of the pool.
+	Stylesheet string
+	// PoolSize is the number of maps for concurrent requests. Defaults to
+	// the number of CPUs.
+	PoolSize int
+	// TileSize in pixel. Defaults to 256.
+	TileSize int
+	// MaxZoom is the highest supported zoom level. Defaults to 22.
+	MaxZoom int
+	// Formats maps all supported file extensions to Mapnik formats.
+	// Defaults to DefaultFormats.
+	Formats map[string]string
+	// Cache for rendered tiles. Tiles are always rendered if nil.
+	Cache TileCache
+	// ErrorLog logs tiles that could not be stored in the Cache. Uses the
+	// standard logger of the log package if nil.
+	ErrorLog *log.Logger
+	// MaxAge for the Cache-Control header. No header is set if zero.
+	MaxAge time.Duration
+}
+
+// Handler is an http.Handler for XYZ tiles.
+type Handler struct {
+	cfg    Config
+	pool   chan *mapnik.Map
+	maps   []*mapnik.Map
+	layers map[string]bool
+}
+
+// New loads the stylesheet into a pool of maps and returns a new Handler.
+func New(cfg Config) (*Handler, error) {
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = runtime.NumCPU()
+	}
+	if cfg.TileSize <= 0 {
+		cfg.TileSize = 256
+	}
+	if cfg.MaxZoom <= 0 {
+		cfg.MaxZoom = 22
+	}
+	if cfg.Formats == nil {
+		cfg.Formats = DefaultFormats
+	}
+
+	h := &Handler{
+		cfg:    cfg,
+		pool:   make(chan *mapnik.Map, cfg.PoolSize),
+		layers: map[string]bool{},
+	}
+	for i := 0; i < cfg.PoolSize; i++ {
+		m := mapnik.New()
+		if err := m.Load(cfg.Stylesheet); err != nil {
+			m.Free()
+			h.Close()
+			return nil, err
+		}
+		m.SetSRS("epsg:3857")
+		h.maps = append(h.maps, m)
+		h.pool <- m
+	}
+	for _, l := range h.maps[0].Layers() {
+		h.layers[l.Name] = true
+	}
+	return h, nil
+}
+
+// Close waits for all running requests and frees all maps.
+func (h *Handler) Close() {
+	for range h.maps {
+		m := <-h.pool
+		m.Free()
+	}
+	h.maps = nil
+}
+
+// TileKey identifies a single tile.
+type TileKey struct {
+	Z, X, Y int
+	// Format is the file extension of the tile (e.g. png).
+	Format string
+	Retina bool
+	// Layers are the selected layers in sorted order. Empty for the
+	// default layers of the stylesheet.
+	Layers []string
+}
+
+// Path returns a relative slash-separated path for the tile, e.g.
+// 'roads,labels/12/2200/1343@2x.png' or 'default/12/2200/1343.png'.
+func (k TileKey) Path() string {
+	layers := "default"
+	if len(k.Layers) > 0 {
+		layers = strings.Join(k.Layers, ",")
+	}
+	suffix := ""
+	if k.Retina {
+		suffix = "@2x"
+	}
+	return fmt.Sprintf("%s/%d/%d/%d%s.%s", layers, k.Z, k.X, k.Y, suffix, k.Format)
+}
+
+// bbox returns the Web Mercator extent of the tile.
+func (k TileKey) bbox() [4]float64 {
+	const origin = 20037508.342789244
+	res := 2 * origin / math.Exp2(float64(k.Z))
+	minx := -origin + float64(k.X)*res
+	maxy := origin - float64(k.Y)*res
+	return [4]float64{minx, maxy - res, minx + res, maxy}
+}
+
+var errInvalidPath = errors.New("invalid tile path, expected /{z}/{x}/{y}.{format}")
+
+// parseTileKey parses the last three elements of path
+// (/{z}/{x}/{y}[@2x].{format}).
+func parseTileKey(path string) (TileKey, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) < 3 {
+		return TileKey{}, errInvalidPath
+	}
+	parts = parts[len(parts)-3:]
+	k := TileKey{}
+	last := parts[2]
+	dot := strings.LastIndex(last, ".")
+	if dot <= 0 {
+		return TileKey{}, errInvalidPath
+	}
+	k.Format = last[dot+1:]
+	last = last[:dot]
+	if strings.HasSuffix(last, "@2x") {
+		k.Retina = true
+		last = strings.TrimSuffix(last, "@2x")
+	}
+	var err error
+	if k.Z, err = strconv.Atoi(parts[0]); err != nil {
+		return TileKey{}, errInvalidPath
+	}
+	if k.X, err = strconv.Atoi(parts[1]); err != nil {
+		return TileKey{}, errInvalidPath
+	}
+	if k.Y, err = strconv.Atoi(last); err != nil {
+		return TileKey{}, errInvalidPath
+	}
+	return k, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k, err := parseTileKey(r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if _, ok := h.cfg.Formats[k.Format]; !ok {
+		http.Error(w, "unsupported format: "+k.Format, http.StatusNotFound)
+		return
+	}
+	if k.Z < 0 || k.Z > h.cfg.MaxZoom || k.X < 0 || k.Y < 0 || k.X >= 1<<k.Z || k.Y >= 1<<k.Z {
+		http.Error(w, "tile out of range", http.StatusNotFound)
+		return
+	}
+	if layers := r.URL.Query().Get("layers"); layers != "" {
+		for _, l := range strings.Split(layers, ",") {
+			if !h.layers[l] {
+				http.Error(w, "unknown layer: "+l, http.StatusBadRequest)
+				return
+			}
+			k.Layers = append(k.Layers, l)
+		}
+		sort.Strings(k.Layers)
+	}
+
+	tile, err := h.tile(r, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + tileETag(tile.Data) + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", tile.ModTime.UTC().Format(http.TimeFormat))
+	if h.cfg.MaxAge > 0 {
+		w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(h.cfg.MaxAge.Seconds())))
+	}
+	if notModified(r, etag, tile.ModTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypes[k.Format])
+	w.Header().Set("Content-Length", strconv.Itoa(len(tile.Data)))
+	w.Write(tile.Data)
+}
+
+func tileETag(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:10])
+}
+
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, e := range strings.Split(inm, ",") {
+			e = strings.TrimSpace(e)
+			if e == etag || e == "W/"+etag || e == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err == nil && !modTime.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// tile returns the tile from the cache or renders it.
+func (h *Handler) tile(r *http.Request, k TileKey) (*Tile, error) {
+	if h.cfg.Cache != nil {
+		tile, err := h.cfg.Cache.Get(k)
+		if err == nil {
+			return tile, nil
+		}
+		// the tile is rendered again if the cache is unreadable
+		if !errors.Is(err, ErrCacheMiss) {
+			h.logf("tileserver: reading %s from cache: %v", k.Path(), err)
+		}
+	}
+
+	data, err := h.render(r, k)
+	if err != nil {
+		return nil, err
+	}
+	tile := &Tile{Data: data, ModTime: time.Now()}
+	if h.cfg.Cache != nil {
+		// the tile is still served if it can't be cached
+		if err := h.cfg.Cache.Put(k, tile); err != nil {
+			h.logf("tileserver: caching %s: %v", k.Path(), err)
+		}
+	}
+	return tile, nil
+}
+
+func (h *Handler) logf(format string, args ...interface{}) {
+	if h.cfg.ErrorLog != nil {
+		h.cfg.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+func (h *Handler) render(r *http.Request, k TileKey) ([]byte, error) {
+	var m *mapnik.Map
+	start := time.Now()
+	select {
+	case m = <-h.pool:
+	case <-r.Context().Done():
+		return nil, r.Context().Err()
+	}
+	mapnik.ObservePoolWait(time.Since(start))
+	defer func() { h.pool <- m }()
+
+	size := h.cfg.TileSize
+	opts := mapnik.RenderOpts{Format: h.cfg.Formats[k.Format]}
+	if k.Retina {
+		size *= 2
+		opts.ScaleFactor = 2
+	}
+	m.Resize(size, size)
+	bbox := k.bbox()
+	m.ZoomTo(bbox[0], bbox[1], bbox[2], bbox[3])
+
+	if len(k.Layers) > 0 {
+		selected := make(map[string]bool, len(k.Layers))
+		for _, l := range k.Layers {
+			selected[l] = true
+		}
+		m.SelectLayers(mapnik.SelectorFunc(func(name string) mapnik.Status {
+			if selected[name] {
+				return mapnik.Include
+			}
+			return mapnik.Exclude
+		}))
+		defer m.ResetLayers()
+	}
+	return m.Render(opts)
+}
@@ -0,0 +1,277 @@
+package tileserver
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/png"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTileKey(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		key  TileKey
+		err  bool
+	}{
+		{"/0/0/0.png", TileKey{Z: 0, X: 0, Y: 0, Format: "png"}, false},
+		{"/tiles/12/2200/1343@2x.webp", TileKey{Z: 12, X: 2200, Y: 1343, Format: "webp", Retina: true}, false},
+		{"/1/2.png", TileKey{}, true},
+		{"/1/2/3", TileKey{}, true},
+		{"/a/2/3.png", TileKey{}, true},
+		{"/1/2/b@2x.png", TileKey{}, true},
+	} {
+		k, err := parseTileKey(tc.path)
+		if tc.err {
+			if err == nil {
+				t.Errorf("expected error for %s", tc.path)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", tc.path, err)
+		}
+		if !reflect.DeepEqual(k, tc.key) {
+			t.Errorf("unexpected key for %s: %#v", tc.path, k)
+		}
+	}
+}
+
+func TestTileKeyBBox(t *testing.T) {
+	const o = 20037508.342789244
+	for _, tc := range []struct {
+		key  TileKey
+		bbox [4]float64
+	}{
+		{TileKey{Z: 0, X: 0, Y: 0}, [4]float64{-o, -o, o, o}},
+		{TileKey{Z: 1, X: 0, Y: 0}, [4]float64{-o, 0, 0, o}},
+		{TileKey{Z: 1, X: 1, Y: 1}, [4]float64{0, -o, o, 0}},
+	} {
+		bbox := tc.key.bbox()
+		for i := range bbox {
+			if math.Abs(bbox[i]-tc.bbox[i]) > 1e-6 {
+				t.Errorf("unexpected bbox for %v: %v", tc.key, bbox)
+				break
+			}
+		}
+	}
+}
+
+func TestTileKeyPath(t *testing.T) {
+	if p := (TileKey{Z: 1, X: 2, Y: 3, Format: "png"}).Path(); p != "default/1/2/3.png" {
+		t.Error("unexpected path", p)
+	}
+	if p := (TileKey{Z: 1, X: 2, Y: 3, Format: "png", Retina: true, Layers: []string{"a", "b"}}).Path(); p != "a,b/1/2/3@2x.png" {
+		t.Error("unexpected path", p)
+	}
+}
+
+func TestFileCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := NewFileCache(dir)
+	k := TileKey{Z: 1, X: 0, Y: 1, Format: "png"}
+	if _, err := c.Get(k); err != ErrCacheMiss {
+		t.Fatal("expected cache miss", err)
+	}
+	modTime := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := c.Put(k, &Tile{Data: []byte("tile"), ModTime: modTime}); err != nil {
+		t.Fatal(err)
+	}
+	tile, err := c.Get(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(tile.Data) != "tile" || !tile.ModTime.Equal(modTime) {
+		t.Error("unexpected tile", tile)
+	}
+}
+
+type memCache struct {
+	mu    sync.Mutex
+	tiles map[string]*Tile
+	gets  int
+}
+
+func (c *memCache) Get(k TileKey) (*Tile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gets++
+	if t, ok := c.tiles[k.Path()]; ok {
+		return t, nil
+	}
+	return nil, ErrCacheMiss
+}
+
+func (c *memCache) Put(k TileKey, t *Tile) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tiles[k.Path()] = t
+	return nil
+}
+
+func newTestHandler(t *testing.T, cache TileCache) *Handler {
+	h, err := New(Config{Stylesheet: "../test/map.xml", PoolSize: 2, Cache: cache, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func get(h http.Handler, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://localhost"+path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandler(t *testing.T) {
+	h := newTestHandler(t, nil)
+
+	for _, tc := range []struct {
+		path string
+		size int
+	}{
+		{"/0/0/0.png", 256},
+		{"/3/4/2@2x.png", 512},
+		{"/3/4/2.png?layers=layerA,layerD", 256},
+	} {
+		rec := get(h, tc.path, nil)
+		if rec.Code != 200 {
+			t.Fatal("unexpected status", rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Error("unexpected content type", ct)
+		}
+		if cc := rec.Header().Get("Cache-Control"); cc != "max-age=3600" {
+			t.Error("unexpected cache control", cc)
+		}
+		img, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, tc.size, tc.size) {
+			t.Error("unexpected image size", img.Bounds())
+		}
+	}
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{"/0/0/0.gif", 404},
+		{"/1/2/0.png", 404},
+		{"/23/0/0.png", 404},
+		{"/0/0/0.png?layers=unknown", 400},
+	} {
+		if rec := get(h, tc.path, nil); rec.Code != tc.status {
+			t.Errorf("unexpected status for %s: %d", tc.path, rec.Code)
+		}
+	}
+}
+
+func TestHandlerCache(t *testing.T) {
+	cache := &memCache{tiles: map[string]*Tile{}}
+	h := newTestHandler(t, cache)
+
+	rec := get(h, "/2/1/1.png", nil)
+	if rec.Code != 200 {
+		t.Fatal("unexpected status", rec.Code, rec.Body.String())
+	}
+	if len(cache.tiles) != 1 {
+		t.Fatal("tile not cached")
+	}
+	etag := rec.Header().Get("ETag")
+	lastModified := rec.Header().Get("Last-Modified")
+	if etag == "" || lastModified == "" {
+		t.Fatal("missing ETag/Last-Modified")
+	}
+
+	// cached tiles are identical
+	rec2 := get(h, "/2/1/1.png", nil)
+	if !bytes.Equal(rec.Body.Bytes(), rec2.Body.Bytes()) || rec2.Header().Get("ETag") != etag {
+		t.Error("cached tile differs")
+	}
+	if cache.gets != 2 {
+		t.Error("unexpected cache gets", cache.gets)
+	}
+
+	if rec := get(h, "/2/1/1.png", http.Header{"If-None-Match": {etag}}); rec.Code != 304 {
+		t.Error("expected 304 for If-None-Match, got", rec.Code)
+	}
+	if rec := get(h, "/2/1/1.png", http.Header{"If-Modified-Since": {lastModified}}); rec.Code != 304 {
+		t.Error("expected 304 for If-Modified-Since, got", rec.Code)
+	}
+	if rec := get(h, "/2/1/1.png", http.Header{"If-None-Match": {`"other"`}}); rec.Code != 200 {
+		t.Error("expected 200 for other ETag, got", rec.Code)
+	}
+}
+
+type failingCache struct{ getErr error }
+
+func (c failingCache) Get(k TileKey) (*Tile, error) {
+	if c.getErr != nil {
+		return nil, c.getErr
+	}
+	return nil, ErrCacheMiss
+}
+
+func (failingCache) Put(k TileKey, t *Tile) error { return errors.New("disk full") }
+
+func TestHandlerCachePutError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	h, err := New(Config{Stylesheet: "../test/map.xml", PoolSize: 1, Cache: failingCache{}, ErrorLog: log.New(buf, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	rec := get(h, "/2/1/1.png", nil)
+	if rec.Code != 200 {
+		t.Fatal("unexpected status", rec.Code, rec.Body.String())
+	}
+	if _, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "disk full") {
+		t.Error("cache error not logged", buf.String())
+	}
+}
+
+func TestHandlerCacheGetError(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cache := failingCache{getErr: errors.New("permission denied")}
+	h, err := New(Config{Stylesheet: "../test/map.xml", PoolSize: 1, Cache: cache, ErrorLog: log.New(buf, "", 0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	rec := get(h, "/2/1/1.png", nil)
+	if rec.Code != 200 {
+		t.Fatal("unexpected status", rec.Code, rec.Body.String())
+	}
+	if _, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes())); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "permission denied") {
+		t.Error("cache error not logged", buf.String())
+	}
+}
@@ -0,0 +1,372 @@
+package mapnik
+
+// #include <stdlib.h>
+// #include "mapnik_c_api.h"
+import "C"
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// Severity of an Issue.
+type Severity int
+
+const (
+	// SeverityError is used for issues that prevent loading or rendering
+	// the stylesheet as intended.
+	SeverityError Severity = iota
+	// SeverityWarning is used for issues that do not affect rendering,
+	// like unused styles.
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Issue is a single problem of a stylesheet.
+type Issue struct {
+	Severity Severity
+	File     string
+	// Line of the issue in File. Zero if the position is unknown.
+	Line    int
+	Message string
+}
+
+func (i Issue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s: %s", i.File, i.Line, i.Severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.File, i.Severity, i.Message)
+}
+
+// FontFaces returns the names of all registered font faces.
+func FontFaces() []string {
+	names := C.mapnik_font_face_names()
+	defer C.free(unsafe.Pointer(names))
+	s := C.GoString(names)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// Validate checks the stylesheet and returns all found issues. It reports:
+//
+//   - errors of loading the stylesheet in strict mode, like unknown
+//     attributes (only the first error, as Mapnik stops loading),
+//   - font faces that are neither registered nor in the font-directory
+//     of the map,
+//   - missing files of symbolizers and datasources,
+//   - styles that are not used by any layer,
+//   - layers without styles,
+//   - datasources that fail to return their extent (e.g. connection
+//     failures),
+//   - invalid SRS of the map and layers.
+//
+// XInclude and external entities are only resolved for loading the
+// stylesheet with Mapnik, other checks only use the stylesheet file itself.
+func Validate(xmlPath string) []Issue {
+	var issues []Issue
+	add := func(sev Severity, line int, format string, args ...interface{}) {
+		issues = append(issues, Issue{Severity: sev, File: xmlPath, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	doc, err := parseStylesheet(xmlPath)
+	if err != nil {
+		add(SeverityError, 0, "%s", err)
+		return issues
+	}
+
+	m := New()
+	defer m.Free()
+	cs := C.CString(xmlPath)
+	defer C.free(unsafe.Pointer(cs))
+	loaded := C.mapnik_map_load_opts(m.m, cs, 1, nil) == 0
+	if !loaded {
+		msg := C.GoString(C.mapnik_map_last_error(m.m))
+		add(SeverityError, errorLine(msg), "loading stylesheet: %s", msg)
+	}
+
+	if doc.fontDir != nil {
+		// Mapnik only registers the fonts for the map, but faces are
+		// checked against the global registry
+		if err := RegisterFonts(doc.fontDir.value); err != nil {
+			add(SeverityError, doc.fontDir.line, "font directory: %s", err)
+		}
+	}
+	faces := map[string]bool{}
+	for _, f := range FontFaces() {
+		faces[f] = true
+	}
+	for _, f := range doc.faces {
+		if !faces[f.value] {
+			add(SeverityError, f.line, "font face %q not registered", f.value)
+		}
+	}
+
+	for _, f := range doc.files {
+		if strings.ContainsAny(f.value, "[{") {
+			continue // expressions are evaluated for each feature
+		}
+		path := doc.filePath(f)
+		if !fileExists(path) && !(f.datasource && fileExists(path+".shp")) {
+			add(SeverityError, f.line, "file %q not found", f.value)
+		}
+	}
+
+	used := map[string]bool{}
+	for _, l := range doc.layers {
+		if len(l.styles) == 0 {
+			add(SeverityWarning, l.line, "layer %q has no styles", l.name)
+		}
+		for _, s := range l.styles {
+			used[s.value] = true
+			if _, ok := doc.styles[s.value]; !ok {
+				add(SeverityError, s.line, "layer %q uses undefined style %q", l.name, s.value)
+			}
+		}
+	}
+	for _, s := range doc.styleOrder {
+		if !used[s] {
+			add(SeverityWarning, doc.styles[s], "style %q not used by any layer", s)
+		}
+	}
+
+	for _, srs := range doc.srs {
+		csrs := C.CString(srs.value)
+		valid := C.mapnik_srs_is_valid(csrs) == 1
+		C.free(unsafe.Pointer(csrs))
+		if !valid {
+			add(SeverityError, srs.line, "invalid SRS %q", srs.value)
+		}
+	}
+
+	if loaded {
+		for _, l := range doc.layers {
+			idx, ok := m.layerIndex(l.name)
+			if !ok {
+				continue // disabled layers are not loaded
+			}
+			var x0, y0, x1, y1 C.double
+			if C.mapnik_map_layer_extent(m.m, C.size_t(idx), &x0, &y0, &x1, &y1) != 0 && C.mapnik_map_last_error(m.m) != nil {
+				add(SeverityError, l.line, "datasource of layer %q: %s", l.name, C.GoString(C.mapnik_map_last_error(m.m)))
+			}
+		}
+	}
+	sort.SliceStable(issues, func(i, j int) bool { return issues[i].Line < issues[j].Line })
+	return issues
+}
+
+var entityRe = regexp.MustCompile(`<!ENTITY\s+([^\s%]+)\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+
+var externalEntityRe = regexp.MustCompile(`<!ENTITY\s+%?\s*[^\s%]+\s+SYSTEM\s+(?:"([^"]*)"|'([^']*)')\s*>`)
+
+var errorLineRe = regexp.MustCompile(`line (\d+)`)
+
+// errorLine returns the line number from a Mapnik config error, or 0.
+func errorLine(msg string) int {
+	match := errorLineRe.FindStringSubmatch(msg)
+	if match == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(match[1])
+	return line
+}
+
+// resolvePath returns path relative to dir, if path is not absolute.
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// xmlValue is an attribute or element value with its line.
+type xmlValue struct {
+	value string
+	line  int
+	// datasource is set for file parameters of datasources.
+	datasource bool
+	// base overrides the stylesheet base for file parameters of
+	// datasources with a base parameter.
+	base string
+}
+
+type xmlLayer struct {
+	name   string
+	line   int
+	styles []xmlValue
+}
+
+// stylesheet contains the elements of a stylesheet that are checked by
+// Validate.
+type stylesheet struct {
+	// base is the directory for relative paths
+	base       string
+	styles     map[string]int
+	styleOrder []string
+	layers     []xmlLayer
+	faces      []xmlValue
+	// fontDir is the font-directory of the map, relative to base
+	fontDir *xmlValue
+	files   []xmlValue
+	srs     []xmlValue
+	// includes are the paths of XInclude and external entity files
+	includes []string
+}
+
+// filePath returns the path of a file attribute or parameter.
+func (doc *stylesheet) filePath(f xmlValue) string {
+	base := doc.base
+	if f.base != "" {
+		base = f.base
+	}
+	return resolvePath(base, f.value)
+}
+
+func parseStylesheet(xmlPath string) (*stylesheet, error) {
+	f, err := os.Open(xmlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseStylesheetReader(f, filepath.Dir(xmlPath))
+}
+
+func parseStylesheetReader(r io.Reader, dir string) (*stylesheet, error) {
+	doc := &stylesheet{base: dir, styles: map[string]int{}}
+	d := xml.NewDecoder(r)
+	// stylesheets often use entities for shared values, internal entities
+	// are resolved from the DOCTYPE, others are kept as is
+	d.Strict = false
+	d.Entity = map[string]string{}
+
+	var stack []string
+	// indexes of the open (possibly nested) layers in doc.layers
+	var layers []int
+	// file and base parameters of the current datasource
+	var dsFile *xmlValue
+	var dsBase string
+	var param string
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing stylesheet: %w", err)
+		}
+		line, _ := d.InputPos()
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+			attrs := map[string]string{}
+			for _, a := range t.Attr {
+				attrs[a.Name.Local] = a.Value
+			}
+			switch t.Name.Local {
+			case "Map":
+				if base, ok := attrs["base"]; ok {
+					if filepath.IsAbs(base) {
+						doc.base = base
+					} else {
+						doc.base = filepath.Join(dir, base)
+					}
+				}
+				if fontDir, ok := attrs["font-directory"]; ok {
+					doc.fontDir = &xmlValue{value: resolvePath(doc.base, fontDir), line: line}
+				}
+			case "Style":
+				if _, ok := doc.styles[attrs["name"]]; !ok {
+					doc.styleOrder = append(doc.styleOrder, attrs["name"])
+				}
+				doc.styles[attrs["name"]] = line
+			case "Layer":
+				doc.layers = append(doc.layers, xmlLayer{name: attrs["name"], line: line})
+				layers = append(layers, len(doc.layers)-1)
+			case "Datasource":
+				dsFile, dsBase = nil, ""
+			case "Parameter":
+				param = attrs["name"]
+			case "include":
+				if href, ok := attrs["href"]; ok {
+					doc.includes = append(doc.includes, resolvePath(dir, href))
+				}
+			}
+			if srs, ok := attrs["srs"]; ok && (t.Name.Local == "Map" || t.Name.Local == "Layer") {
+				doc.srs = append(doc.srs, xmlValue{value: srs, line: line})
+			}
+			if face, ok := attrs["face-name"]; ok {
+				doc.faces = append(doc.faces, xmlValue{value: face, line: line})
+			}
+			if file, ok := attrs["file"]; ok && t.Name.Local != "Map" {
+				doc.files = append(doc.files, xmlValue{value: file, line: line})
+			}
+		case xml.Directive:
+			for _, match := range entityRe.FindAllStringSubmatch(string(t), -1) {
+				d.Entity[match[1]] = match[2] + match[3]
+			}
+			for _, match := range externalEntityRe.FindAllStringSubmatch(string(t), -1) {
+				doc.includes = append(doc.includes, resolvePath(dir, match[1]+match[2]))
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			switch t.Name.Local {
+			case "StyleName":
+				if len(layers) > 0 {
+					l := &doc.layers[layers[len(layers)-1]]
+					l.styles = append(l.styles, xmlValue{value: value, line: line})
+				}
+			case "Layer":
+				if len(layers) > 0 {
+					layers = layers[:len(layers)-1]
+				}
+			case "Parameter":
+				if len(stack) > 1 && stack[len(stack)-2] == "Datasource" {
+					switch param {
+					case "file":
+						dsFile = &xmlValue{value: value, line: line, datasource: true}
+					case "base":
+						dsBase = value
+					}
+				}
+			case "Datasource":
+				if dsFile != nil {
+					if dsBase != "" && !filepath.IsAbs(dsBase) {
+						dsFile.base = filepath.Join(doc.base, dsBase)
+					} else {
+						dsFile.base = dsBase
+					}
+					doc.files = append(doc.files, *dsFile)
+				}
+			}
+			text.Reset()
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,153 @@
+package mapnik
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	issues := Validate("test/map.xml")
+	if len(issues) != 0 {
+		t.Error("unexpected issues", issues)
+	}
+}
+
+const invalidStylesheet = `<?xml version="1.0" encoding="utf-8"?>
+<Map srs="epsg:4326" background-color="steelblue">
+    <Style name="used">
+        <Rule>
+            <PolygonSymbolizer fill="red" unknown-attribute="1" />
+            <PointSymbolizer file="missing.png" />
+            <TextSymbolizer face-name="Missing Font Regular">[name]</TextSymbolizer>
+        </Rule>
+    </Style>
+    <Style name="unused">
+        <Rule>
+            <PolygonSymbolizer fill="blue" />
+        </Rule>
+    </Style>
+    <Layer name="layerA" srs="invalid-srs">
+        <StyleName>used</StyleName>
+        <StyleName>undefined</StyleName>
+        <Datasource>
+            <Parameter name="file">missing.geojson</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+    <Layer name="layerB">
+        <Datasource>
+            <Parameter name="file">map.geojson</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+</Map>
+`
+
+func TestValidateIssues(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "style.xml")
+	if err := os.WriteFile(fname, []byte(invalidStylesheet), 0644); err != nil {
+		t.Fatal(err)
+	}
+	geojson, err := os.ReadFile("test/map.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "map.geojson"), geojson, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	issues := Validate(fname)
+	expected := []struct {
+		line     int
+		severity Severity
+		msg      string
+	}{
+		{6, SeverityError, `file "missing.png" not found`},
+		{7, SeverityError, `font face "Missing Font Regular" not registered`},
+		{10, SeverityWarning, `style "unused" not used by any layer`},
+		{15, SeverityError, `invalid SRS "invalid-srs"`},
+		{17, SeverityError, `layer "layerA" uses undefined style "undefined"`},
+		{19, SeverityError, `file "missing.geojson" not found`},
+		{23, SeverityWarning, `layer "layerB" has no styles`},
+	}
+	for _, e := range expected {
+		found := false
+		for _, i := range issues {
+			if i.Line == e.line && i.Severity == e.severity && i.Message == e.msg {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("missing issue %d: %s in %v", e.line, e.msg, issues)
+		}
+	}
+
+	loadErr := false
+	for _, i := range issues {
+		assertEqual(t, fname, i.File)
+		if strings.HasPrefix(i.Message, "loading stylesheet: ") {
+			loadErr = true
+		}
+	}
+	if !loadErr {
+		t.Error("missing load error in", issues)
+	}
+}
+
+func TestErrorLine(t *testing.T) {
+	assertEqual(t, 12, errorLine(`Unknown attribute 'foo' in PolygonSymbolizer at line 12 of 'style.xml'`))
+	assertEqual(t, 0, errorLine(`unknown error`))
+}
+
+func TestParseStylesheet(t *testing.T) {
+	doc, err := parseStylesheetReader(strings.NewReader(`<!DOCTYPE Map [<!ENTITY srs "epsg:3857">]>
+<Map srs="&srs;" base="data">
+  <Style name="a"/>
+  <Layer name="l">
+    <StyleName>a</StyleName>
+    <Datasource>
+      <Parameter name="base">/shapes</Parameter>
+      <Parameter name="file">roads</Parameter>
+    </Datasource>
+  </Layer>
+</Map>`), "/styles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "/styles/data", doc.base)
+	assertEqual(t, []xmlValue{{value: "epsg:3857", line: 2}}, doc.srs)
+	assertEqual(t, map[string]int{"a": 3}, doc.styles)
+	assertEqual(t, []xmlLayer{{name: "l", line: 4, styles: []xmlValue{{value: "a", line: 5}}}}, doc.layers)
+	assertEqual(t, []xmlValue{{value: "roads", line: 8, datasource: true, base: "/shapes"}}, doc.files)
+}
+
+func TestParseStylesheetNestedLayers(t *testing.T) {
+	doc, err := parseStylesheetReader(strings.NewReader(`<Map base="data" font-directory="fonts">
+  <Layer name="group">
+    <Layer name="a">
+      <StyleName>a</StyleName>
+    </Layer>
+    <StyleName>group</StyleName>
+    <Layer name="b">
+      <StyleName>b</StyleName>
+    </Layer>
+  </Layer>
+  <Layer name="c">
+    <StyleName>c</StyleName>
+  </Layer>
+</Map>`), "/styles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, &xmlValue{value: "/styles/data/fonts", line: 1}, doc.fontDir)
+	assertEqual(t, []xmlLayer{
+		{name: "group", line: 2, styles: []xmlValue{{value: "group", line: 6}}},
+		{name: "a", line: 3, styles: []xmlValue{{value: "a", line: 4}}},
+		{name: "b", line: 7, styles: []xmlValue{{value: "b", line: 8}}},
+		{name: "c", line: 11, styles: []xmlValue{{value: "c", line: 12}}},
+	}, doc.layers)
+}
@@ -0,0 +1,202 @@
+package mapnik
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WatchOpts defines options for NewWatcher.
+type WatchOpts struct {
+	// Interval between checks for modified files. Defaults to one second.
+	Interval time.Duration
+	// LoadOpts for the initial load and all reloads.
+	LoadOpts LoadOpts
+	// OnReload is called after each reload with the load error, or with nil
+	// if the new map replaced the current map.
+	OnReload func(err error)
+}
+
+// Watcher loads a stylesheet and reloads it into a new Map whenever the
+// stylesheet or one of the files it references changes.
+//
+// Watched files are the stylesheet, XInclude and external entity files,
+// files of symbolizers and datasources, and the .shx, .dbf, .prj and .index
+// files of shapefiles. Files are checked for modified times and sizes in
+// regular intervals.
+type Watcher struct {
+	stylesheet string
+	opts       WatchOpts
+	m          atomic.Value // *Map
+
+	mu    sync.Mutex // protects files and serializes reloads
+	files map[string]fileState
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type fileState struct {
+	modTime int64
+	size    int64
+	exists  bool
+}
+
+// NewWatcher loads the stylesheet and starts watching for changes. It
+// returns an error if the initial load fails.
+func NewWatcher(stylesheet string, opts WatchOpts) (*Watcher, error) {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	w := &Watcher{
+		stylesheet: stylesheet,
+		opts:       opts,
+		done:       make(chan struct{}),
+	}
+	if err := w.reload(); err != nil {
+		return nil, err
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w, nil
+}
+
+// Map returns the current map. The map is replaced after each successful
+// reload, call Map for each use and do not keep the returned map.
+//
+// The map is shared by all callers of Map and a Map is not safe for
+// concurrent use. Use Clone for concurrent renderings. Do not call Free on
+// the returned map, replaced maps are released by the garbage collector as
+// they might still be in use.
+func (w *Watcher) Map() *Map {
+	return w.m.Load().(*Map)
+}
+
+// Reload loads the stylesheet into a new map, regardless of any changes.
+// The current map is kept if the new map fails to load.
+func (w *Watcher) Reload() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.reload()
+	if w.opts.OnReload != nil {
+		w.opts.OnReload(err)
+	}
+	return err
+}
+
+// Close stops watching. The current map remains valid.
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() { close(w.done) })
+	w.wg.Wait()
+}
+
+func (w *Watcher) run() {
+	defer w.wg.Done()
+	t := time.NewTicker(w.opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-t.C:
+			if w.modified() {
+				w.Reload()
+			}
+		}
+	}
+}
+
+// reload loads a new map. Requires w.mu, or exclusive access to w.
+func (w *Watcher) reload() error {
+	// snapshot before loading, so that changes during the load trigger
+	// another reload
+	files := map[string]fileState{}
+	for _, f := range referencedFiles(w.stylesheet, w.opts.LoadOpts.BasePath) {
+		files[f] = statFile(f)
+	}
+	w.files = files
+
+	m := New()
+	if err := m.LoadWithOpts(w.stylesheet, w.opts.LoadOpts); err != nil {
+		m.Free()
+		return err
+	}
+	w.m.Store(m)
+	return nil
+}
+
+func (w *Watcher) modified() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for f, state := range w.files {
+		if statFile(f) != state {
+			return true
+		}
+	}
+	return false
+}
+
+func statFile(path string) fileState {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: fi.ModTime().UnixNano(), size: fi.Size(), exists: true}
+}
+
+// shapefileExts are the extensions of all files of a shapefile datasource.
+var shapefileExts = []string{".shp", ".shx", ".dbf", ".prj", ".index"}
+
+// referencedFiles returns the stylesheet and all files it references.
+// Included files are parsed for further references. Files that fail to
+// parse are returned, but not their references.
+func referencedFiles(stylesheet, basePath string) []string {
+	var files []string
+	seen := map[string]bool{}
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	// relative paths in included files are resolved with the base of the
+	// main stylesheet
+	base := basePath
+	queue := []string{stylesheet}
+	for len(queue) > 0 {
+		path := queue[0]
+		queue = queue[1:]
+		if seen[path] {
+			continue
+		}
+		add(path)
+		doc, err := parseStylesheet(path)
+		if err != nil {
+			continue
+		}
+		if base == "" {
+			base = doc.base
+		}
+		doc.base = base
+		queue = append(queue, doc.includes...)
+		for _, f := range doc.files {
+			if strings.ContainsAny(f.value, "[{") {
+				continue // expressions are evaluated for each feature
+			}
+			p := doc.filePath(f)
+			add(p)
+			if ext := filepath.Ext(p); f.datasource && (ext == "" || ext == ".shp") {
+				p = strings.TrimSuffix(p, ext)
+				for _, ext := range shapefileExts {
+					add(p + ext)
+				}
+			}
+		}
+	}
+	return files
+}
@@ -0,0 +1,116 @@
+package mapnik
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, fname string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(fname, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	dir := t.TempDir()
+	style, err := os.ReadFile("test/map.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	geojson, err := os.ReadFile("test/map.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fname := filepath.Join(dir, "map.xml")
+	writeFile(t, fname, style)
+	writeFile(t, filepath.Join(dir, "map.geojson"), geojson)
+
+	reloaded := make(chan error, 10)
+	w, err := NewWatcher(fname, WatchOpts{
+		Interval: 10 * time.Millisecond,
+		OnReload: func(err error) { reloaded <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	assertEqual(t, 4, len(w.Map().Layers()))
+
+	wait := func() error {
+		t.Helper()
+		select {
+		case err := <-reloaded:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("no reload")
+		}
+		return nil
+	}
+
+	// datasource changes trigger a reload
+	first := w.Map()
+	writeFile(t, filepath.Join(dir, "map.geojson"), append(geojson, '\n'))
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Map() == first {
+		t.Error("map not replaced")
+	}
+
+	writeFile(t, fname, []byte(`<Map srs="epsg:4326"><Layer name="new" /></Map>`))
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, "new", w.Map().Layers()[0].Name)
+
+	// invalid stylesheets keep the current map
+	writeFile(t, fname, []byte(`<Map`))
+	if err := wait(); err == nil {
+		t.Error("expected load error")
+	}
+	assertEqual(t, "new", w.Map().Layers()[0].Name)
+}
+
+func TestWatcherLoadError(t *testing.T) {
+	if _, err := NewWatcher("test/missing.xml", WatchOpts{}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestReferencedFiles(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "map.xml")
+	writeFile(t, fname, []byte(`<!DOCTYPE Map [<!ENTITY layers SYSTEM "layers.xml">]>
+<Map srs="epsg:4326" base="data">
+  <Style name="s">
+    <Rule>
+      <PointSymbolizer file="symbols/point.svg" />
+      <MarkersSymbolizer file="[symbol]" />
+    </Rule>
+  </Style>
+  &layers;
+</Map>`))
+	writeFile(t, filepath.Join(dir, "layers.xml"), []byte(`<Layer name="roads">
+  <StyleName>s</StyleName>
+  <Datasource>
+    <Parameter name="type">shape</Parameter>
+    <Parameter name="file">roads</Parameter>
+  </Datasource>
+</Layer>`))
+
+	data := filepath.Join(dir, "data")
+	assertEqual(t, []string{
+		fname,
+		filepath.Join(data, "symbols/point.svg"),
+		filepath.Join(dir, "layers.xml"),
+		filepath.Join(data, "roads"),
+		filepath.Join(data, "roads.shp"),
+		filepath.Join(data, "roads.shx"),
+		filepath.Join(data, "roads.dbf"),
+		filepath.Join(data, "roads.prj"),
+		filepath.Join(data, "roads.index"),
+	}, referencedFiles(fname, ""))
+}
@@ -0,0 +1,245 @@
+package wms
+
+import (
+	"bytes"
+	"encoding/xml"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+var templateFuncs = template.FuncMap{
+	"xml": func(s string) string {
+		buf := bytes.Buffer{}
+		xml.EscapeText(&buf, []byte(s))
+		return buf.String()
+	},
+	"num": func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	},
+	"axis": func(version, srs string, bbox [4]float64) [4]float64 {
+		if version == "1.3.0" && latLonCRS[srs] {
+			return [4]float64{bbox[1], bbox[0], bbox[3], bbox[2]}
+		}
+		return bbox
+	},
+}
+
+var capabilities111 = template.Must(template.New("1.1.1").Funcs(templateFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE WMT_MS_Capabilities SYSTEM "http://schemas.opengis.net/wms/1.1.1/WMS_MS_Capabilities.dtd">
+<WMT_MS_Capabilities version="1.1.1" xmlns:xlink="http://www.w3.org/1999/xlink">
+  <Service>
+    <Name>OGC:WMS</Name>
+    <Title>{{ xml .Title }}</Title>
+    <Abstract>{{ xml .Abstract }}</Abstract>
+    <OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}"/>
+  </Service>
+  <Capability>
+    <Request>
+      <GetCapabilities>
+        <Format>application/vnd.ogc.wms_xml</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}?"/></Get></HTTP></DCPType>
+      </GetCapabilities>
+      <GetMap>
+{{- range .Formats }}
+        <Format>{{ xml . }}</Format>
+{{- end }}
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}?"/></Get></HTTP></DCPType>
+      </GetMap>
+      <GetFeatureInfo>
+        <Format>text/plain</Format>
+        <Format>application/json</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}?"/></Get></HTTP></DCPType>
+      </GetFeatureInfo>
+    </Request>
+    <Exception>
+      <Format>application/vnd.ogc.se_xml</Format>
+    </Exception>
+    <Layer>
+      <Title>{{ xml .Title }}</Title>
+{{- range .SRS }}
+      <SRS>{{ xml . }}</SRS>
+{{- end }}
+{{- if .Root.HasExtent }}
+      <LatLonBoundingBox minx="{{ num (index .Root.LatLon 0) }}" miny="{{ num (index .Root.LatLon 1) }}" maxx="{{ num (index .Root.LatLon 2) }}" maxy="{{ num (index .Root.LatLon 3) }}"/>
+{{- end }}
+{{- range .Layers }}
+      <Layer queryable="1">
+        <Name>{{ xml .Name }}</Name>
+        <Title>{{ xml .Name }}</Title>
+{{- if .HasExtent }}
+        <LatLonBoundingBox minx="{{ num (index .LatLon 0) }}" miny="{{ num (index .LatLon 1) }}" maxx="{{ num (index .LatLon 2) }}" maxy="{{ num (index .LatLon 3) }}"/>
+{{- range $srs, $bbox := .BBoxes }}
+{{- if ne $srs "CRS:84" }}
+        <BoundingBox SRS="{{ xml $srs }}" minx="{{ num (index $bbox 0) }}" miny="{{ num (index $bbox 1) }}" maxx="{{ num (index $bbox 2) }}" maxy="{{ num (index $bbox 3) }}"/>
+{{- end }}
+{{- end }}
+{{- end }}
+      </Layer>
+{{- end }}
+    </Layer>
+  </Capability>
+</WMT_MS_Capabilities>
+`))
+
+var capabilities130 = template.Must(template.New("1.3.0").Funcs(templateFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<WMS_Capabilities version="1.3.0" xmlns="http://www.opengis.net/wms" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.opengis.net/wms http://schemas.opengis.net/wms/1.3.0/capabilities_1_3_0.xsd">
+  <Service>
+    <Name>WMS</Name>
+    <Title>{{ xml .Title }}</Title>
+    <Abstract>{{ xml .Abstract }}</Abstract>
+    <OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}"/>
+  </Service>
+  <Capability>
+    <Request>
+      <GetCapabilities>
+        <Format>text/xml</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}?"/></Get></HTTP></DCPType>
+      </GetCapabilities>
+      <GetMap>
+{{- range .Formats }}
+        <Format>{{ xml . }}</Format>
+{{- end }}
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}?"/></Get></HTTP></DCPType>
+      </GetMap>
+      <GetFeatureInfo>
+        <Format>text/plain</Format>
+        <Format>application/json</Format>
+        <DCPType><HTTP><Get><OnlineResource xlink:type="simple" xlink:href="{{ xml .URL }}?"/></Get></HTTP></DCPType>
+      </GetFeatureInfo>
+    </Request>
+    <Exception>
+      <Format>XML</Format>
+    </Exception>
+    <Layer>
+      <Title>{{ xml .Title }}</Title>
+{{- range .SRS }}
+      <CRS>{{ xml . }}</CRS>
+{{- end }}
+{{- if .Root.HasExtent }}
+      <EX_GeographicBoundingBox>
+        <westBoundLongitude>{{ num (index .Root.LatLon 0) }}</westBoundLongitude>
+        <eastBoundLongitude>{{ num (index .Root.LatLon 2) }}</eastBoundLongitude>
+        <southBoundLatitude>{{ num (index .Root.LatLon 1) }}</southBoundLatitude>
+        <northBoundLatitude>{{ num (index .Root.LatLon 3) }}</northBoundLatitude>
+      </EX_GeographicBoundingBox>
+{{- end }}
+{{- range .Layers }}
+      <Layer queryable="1">
+        <Name>{{ xml .Name }}</Name>
+        <Title>{{ xml .Name }}</Title>
+{{- if .HasExtent }}
+        <EX_GeographicBoundingBox>
+          <westBoundLongitude>{{ num (index .LatLon 0) }}</westBoundLongitude>
+          <eastBoundLongitude>{{ num (index .LatLon 2) }}</eastBoundLongitude>
+          <southBoundLatitude>{{ num (index .LatLon 1) }}</southBoundLatitude>
+          <northBoundLatitude>{{ num (index .LatLon 3) }}</northBoundLatitude>
+        </EX_GeographicBoundingBox>
+{{- range $srs, $bbox := .BBoxes }}
+{{- with axis "1.3.0" $srs $bbox }}
+        <BoundingBox CRS="{{ xml $srs }}" minx="{{ num (index . 0) }}" miny="{{ num (index . 1) }}" maxx="{{ num (index . 2) }}" maxy="{{ num (index . 3) }}"/>
+{{- end }}
+{{- end }}
+{{- end }}
+      </Layer>
+{{- end }}
+    </Layer>
+  </Capability>
+</WMS_Capabilities>
+`))
+
+type capabilitiesContext struct {
+	Title    string
+	Abstract string
+	URL      string
+	Formats  []string
+	SRS      []string
+	Root     layerInfo
+	Layers   []layerInfo
+}
+
+func (h *Handler) capabilities(w http.ResponseWriter, r *http.Request, version string) error {
+	ctx := capabilitiesContext{
+		Title:    h.cfg.Title,
+		Abstract: h.cfg.Abstract,
+		URL:      h.cfg.URL,
+		Layers:   h.layers,
+		Root:     rootLayer(h.layers),
+	}
+	if ctx.URL == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		ctx.URL = scheme + "://" + r.Host + r.URL.Path
+	}
+	for f := range h.cfg.Formats {
+		ctx.Formats = append(ctx.Formats, f)
+	}
+	sort.Strings(ctx.Formats)
+	for srs := range h.cfg.SRS {
+		if version == "1.1.1" && srs == "CRS:84" {
+			continue
+		}
+		ctx.SRS = append(ctx.SRS, srs)
+	}
+	sort.Strings(ctx.SRS)
+
+	tmpl := capabilities130
+	contentType := "text/xml"
+	if version == "1.1.1" {
+		tmpl = capabilities111
+		contentType = "application/vnd.ogc.wms_xml"
+	}
+	buf := bytes.Buffer{}
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Write(buf.Bytes())
+	return nil
+}
+
+// rootLayer returns a layerInfo with the union of all layer extents.
+func rootLayer(layers []layerInfo) layerInfo {
+	root := layerInfo{LatLon: [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}}
+	for _, l := range layers {
+		if !l.HasExtent {
+			continue
+		}
+		root.HasExtent = true
+		root.LatLon[0] = math.Min(root.LatLon[0], l.LatLon[0])
+		root.LatLon[1] = math.Min(root.LatLon[1], l.LatLon[1])
+		root.LatLon[2] = math.Max(root.LatLon[2], l.LatLon[2])
+		root.LatLon[3] = math.Max(root.LatLon[3], l.LatLon[3])
+	}
+	return root
+}
+
+var exceptionTemplate = template.Must(template.New("exception").Funcs(templateFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+{{- if eq .Version "1.1.1" }}
+<ServiceExceptionReport version="1.1.1">
+{{- else }}
+<ServiceExceptionReport version="1.3.0" xmlns="http://www.opengis.net/ogc" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.opengis.net/ogc http://schemas.opengis.net/wms/1.3.0/exceptions_1_3_0.xsd">
+{{- end }}
+  <ServiceException{{ if .Code }} code="{{ xml .Code }}"{{ end }}>{{ xml .Message }}</ServiceException>
+</ServiceExceptionReport>
+`))
+
+func writeException(w http.ResponseWriter, e *serviceError, version string) {
+	buf := bytes.Buffer{}
+	exceptionTemplate.Execute(&buf, struct {
+		Version string
+		Code    string
+		Message string
+	}{version, e.code, e.msg})
+	if version == "1.1.1" {
+		w.Header().Set("Content-Type", "application/vnd.ogc.se_xml")
+	} else {
+		w.Header().Set("Content-Type", "text/xml")
+	}
+	w.WriteHeader(e.status)
+	w.Write(buf.Bytes())
+}
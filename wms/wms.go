@@ -0,0 +1,479 @@
+// Package wms implements an OGC WMS 1.1.1 and 1.3.0 http.Handler on top of
+// mapnik.Map.
+//
+// The handler supports GetMap, GetCapabilities and GetFeatureInfo requests.
+// Each request uses a Map from a pool of maps that are loaded from the same
+// stylesheet.
+package wms
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/color"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mapnik "github.com/omniscale/go-mapnik/v3"
+)
+
+// DefaultSRS are the supported SRS/CRS codes with their Mapnik projection.
+var DefaultSRS = map[string]string{
+	"EPSG:4326": "epsg:4326",
+	"EPSG:3857": "epsg:3857",
+	"CRS:84":    "epsg:4326",
+}
+
+// DefaultFormats are the supported image formats with their Mapnik format.
+var DefaultFormats = map[string]string{
+	"image/png":            "png32",
+	"image/png; mode=8bit": "png256",
+	"image/jpeg":           "jpeg85",
+	"image/webp":           "webp",
+}
+
+// latLonCRS are geographic CRS with latitude/longitude axis order in WMS 1.3.0.
+var latLonCRS = map[string]bool{
+	"EPSG:4326": true,
+	"EPSG:4258": true,
+	"EPSG:4269": true,
+	"EPSG:4267": true,
+	"EPSG:4230": true,
+	"EPSG:4314": true,
+	"EPSG:4283": true,
+}
+
+// Config for the WMS Handler.
+type Config struct {
+	// Stylesheet is the Mapnik XML that is loaded for each map of the pool.
+	Stylesheet string
+	// PoolSize is the number of maps for concurrent requests. Defaults to
+	// the number of CPUs.
+	PoolSize int
+	// Title and Abstract of the service for the capabilities document.
+	Title    string
+	Abstract string
+	// URL of the service for the capabilities document. Defaults to the URL
+	// of the GetCapabilities request.
+	URL string
+	// SRS maps all supported WMS SRS/CRS codes to Mapnik projections.
+	// Defaults to DefaultSRS.
+	SRS map[string]string
+	// Formats maps all supported image formats to Mapnik formats.
+	// Defaults to DefaultFormats.
+	Formats map[string]string
+	// MaxWidth and MaxHeight of GetMap requests in pixel. Defaults to 4096.
+	MaxWidth  int
+	MaxHeight int
+}
+
+// Handler is an http.Handler for WMS requests.
+type Handler struct {
+	cfg    Config
+	pool   chan *mapnik.Map
+	maps   []*mapnik.Map
+	layers []layerInfo
+}
+
+type layerInfo struct {
+	Name string
+	// LatLon is the extent in EPSG:4326 as minx, miny, maxx, maxy.
+	LatLon    [4]float64
+	HasExtent bool
+	// BBoxes are the extents in all supported SRS in x/y axis order.
+	BBoxes map[string][4]float64
+}
+
+// New loads the stylesheet into a pool of maps and returns a new Handler.
+func New(cfg Config) (*Handler, error) {
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = runtime.NumCPU()
+	}
+	if cfg.SRS == nil {
+		cfg.SRS = DefaultSRS
+	}
+	if cfg.Formats == nil {
+		cfg.Formats = DefaultFormats
+	}
+	if cfg.MaxWidth <= 0 {
+		cfg.MaxWidth = 4096
+	}
+	if cfg.MaxHeight <= 0 {
+		cfg.MaxHeight = 4096
+	}
+	if cfg.Title == "" {
+		cfg.Title = "go-mapnik WMS"
+	}
+
+	h := &Handler{
+		cfg:  cfg,
+		pool: make(chan *mapnik.Map, cfg.PoolSize),
+	}
+	for i := 0; i < cfg.PoolSize; i++ {
+		m := mapnik.New()
+		if err := m.Load(cfg.Stylesheet); err != nil {
+			m.Free()
+			h.Close()
+			return nil, err
+		}
+		h.maps = append(h.maps, m)
+		h.pool <- m
+	}
+	h.layers = h.layerInfos(h.maps[0])
+	return h, nil
+}
+
+// Close waits for all running requests and frees all maps.
+func (h *Handler) Close() {
+	for range h.maps {
+		m := <-h.pool
+		m.Free()
+	}
+	h.maps = nil
+}
+
+func (h *Handler) layerInfos(m *mapnik.Map) []layerInfo {
+	var infos []layerInfo
+	for _, l := range m.Layers() {
+		info := layerInfo{Name: l.Name, BBoxes: map[string][4]float64{}}
+		if l.Extent != [4]float64{} {
+			if latLon, err := mapnik.Transform(l.SRS, "epsg:4326", l.Extent); err == nil {
+				info.LatLon = latLon
+				info.HasExtent = true
+			}
+			for code, srs := range h.cfg.SRS {
+				if bbox, err := mapnik.Transform(l.SRS, srs, l.Extent); err == nil {
+					info.BBoxes[code] = bbox
+				}
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func (h *Handler) hasLayer(name string) bool {
+	for _, l := range h.layers {
+		if l.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// params are the query parameters with lower-case keys.
+type params map[string]string
+
+func parseParams(r *http.Request) params {
+	p := params{}
+	for k, v := range r.URL.Query() {
+		if len(v) > 0 {
+			p[strings.ToLower(k)] = v[0]
+		}
+	}
+	return p
+}
+
+// serviceError is reported as a WMS ServiceException.
+type serviceError struct {
+	code   string
+	msg    string
+	status int
+}
+
+func (e *serviceError) Error() string {
+	return e.msg
+}
+
+func invalidParam(code, format string, args ...interface{}) *serviceError {
+	return &serviceError{code: code, msg: fmt.Sprintf(format, args...), status: http.StatusBadRequest}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p := parseParams(r)
+	version := p["version"]
+	if version == "" {
+		version = p["wmtver"]
+	}
+	if version != "1.1.1" {
+		version = "1.3.0"
+	}
+
+	var err error
+	switch strings.ToLower(p["request"]) {
+	case "getcapabilities", "capabilities":
+		err = h.capabilities(w, r, version)
+	case "getmap", "map":
+		err = h.getMap(w, r, p, version)
+	case "getfeatureinfo", "feature_info":
+		err = h.featureInfo(w, r, p, version)
+	default:
+		err = &serviceError{code: "OperationNotSupported", msg: "unknown request: " + p["request"], status: http.StatusBadRequest}
+	}
+	if err != nil {
+		var se *serviceError
+		if !errors.As(err, &se) {
+			se = &serviceError{msg: err.Error(), status: http.StatusInternalServerError}
+		}
+		writeException(w, se, version)
+	}
+}
+
+// mapRequest contains the common parameters of GetMap and GetFeatureInfo.
+type mapRequest struct {
+	version     string
+	srs         string
+	bbox        [4]float64
+	width       int
+	height      int
+	layers      []string
+	transparent bool
+	bgcolor     *color.NRGBA
+}
+
+func (h *Handler) parseMapRequest(p params, version string) (*mapRequest, error) {
+	req := &mapRequest{version: version}
+
+	srsParam := "srs"
+	srsCode := "InvalidSRS"
+	if version == "1.3.0" {
+		srsParam = "crs"
+		srsCode = "InvalidCRS"
+	}
+	req.srs = strings.ToUpper(p[srsParam])
+	if _, ok := h.cfg.SRS[req.srs]; !ok {
+		return nil, invalidParam(srsCode, "unsupported %s: %q", strings.ToUpper(srsParam), p[srsParam])
+	}
+
+	parts := strings.Split(p["bbox"], ",")
+	if len(parts) != 4 {
+		return nil, invalidParam("MissingParameterValue", "invalid BBOX: %q", p["bbox"])
+	}
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, invalidParam("InvalidParameterValue", "invalid BBOX: %q", p["bbox"])
+		}
+		req.bbox[i] = v
+	}
+	if version == "1.3.0" && latLonCRS[req.srs] {
+		req.bbox = [4]float64{req.bbox[1], req.bbox[0], req.bbox[3], req.bbox[2]}
+	}
+	if req.bbox[0] >= req.bbox[2] || req.bbox[1] >= req.bbox[3] {
+		return nil, invalidParam("InvalidParameterValue", "invalid BBOX: %q", p["bbox"])
+	}
+
+	var err error
+	if req.width, err = strconv.Atoi(p["width"]); err != nil || req.width <= 0 || req.width > h.cfg.MaxWidth {
+		return nil, invalidParam("InvalidParameterValue", "invalid WIDTH: %q", p["width"])
+	}
+	if req.height, err = strconv.Atoi(p["height"]); err != nil || req.height <= 0 || req.height > h.cfg.MaxHeight {
+		return nil, invalidParam("InvalidParameterValue", "invalid HEIGHT: %q", p["height"])
+	}
+
+	if p["layers"] == "" {
+		return nil, invalidParam("MissingParameterValue", "missing LAYERS")
+	}
+	for _, l := range strings.Split(p["layers"], ",") {
+		if !h.hasLayer(l) {
+			return nil, invalidParam("LayerNotDefined", "unknown layer: %q", l)
+		}
+		req.layers = append(req.layers, l)
+	}
+
+	req.transparent = strings.EqualFold(p["transparent"], "true")
+	if bg := p["bgcolor"]; bg != "" {
+		c, err := parseColor(bg)
+		if err != nil {
+			return nil, invalidParam("InvalidParameterValue", "invalid BGCOLOR: %q", bg)
+		}
+		req.bgcolor = &c
+	}
+	return req, nil
+}
+
+func parseColor(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.NRGBA{}, errors.New("invalid color")
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, err
+	}
+	return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, nil
+}
+
+// withMap calls f with a map from the pool that is prepared for req.
+func (h *Handler) withMap(r *http.Request, req *mapRequest, f func(m *mapnik.Map) error) error {
+	var m *mapnik.Map
+	start := time.Now()
+	select {
+	case m = <-h.pool:
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+	mapnik.ObservePoolWait(time.Since(start))
+	defer func() { h.pool <- m }()
+
+	m.Resize(req.width, req.height)
+	m.SetSRS(h.cfg.SRS[req.srs])
+	m.ZoomTo(req.bbox[0], req.bbox[1], req.bbox[2], req.bbox[3])
+
+	selected := make(map[string]bool, len(req.layers))
+	for _, l := range req.layers {
+		selected[l] = true
+	}
+	m.SelectLayers(mapnik.SelectorFunc(func(name string) mapnik.Status {
+		if selected[name] {
+			return mapnik.Include
+		}
+		return mapnik.Exclude
+	}))
+	defer m.ResetLayers()
+
+	bg := m.BackgroundColor()
+	defer m.SetBackgroundColor(bg)
+	if req.transparent {
+		m.SetBackgroundColor(color.NRGBA{})
+	} else if req.bgcolor != nil {
+		m.SetBackgroundColor(*req.bgcolor)
+	} else {
+		// opaque requests default to a white BGCOLOR, regardless of the
+		// (possibly transparent) background of the stylesheet
+		m.SetBackgroundColor(color.NRGBA{255, 255, 255, 255})
+	}
+
+	return f(m)
+}
+
+func (h *Handler) getMap(w http.ResponseWriter, r *http.Request, p params, version string) error {
+	req, err := h.parseMapRequest(p, version)
+	if err != nil {
+		return err
+	}
+	mimeType := p["format"]
+	format, ok := h.cfg.Formats[mimeType]
+	if !ok {
+		return invalidParam("InvalidFormat", "unsupported FORMAT: %q", mimeType)
+	}
+
+	var b []byte
+	err = h.withMap(r, req, func(m *mapnik.Map) error {
+		var err error
+		b, err = m.Render(mapnik.RenderOpts{Format: format})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if idx := strings.Index(mimeType, ";"); idx > 0 {
+		mimeType = mimeType[:idx]
+	}
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Write(b)
+	return nil
+}
+
+type layerFeatures struct {
+	Name     string          `json:"name"`
+	Features []featureResult `json:"features"`
+}
+
+type featureResult struct {
+	ID         int64             `json:"id"`
+	Properties map[string]string `json:"properties"`
+}
+
+func (h *Handler) featureInfo(w http.ResponseWriter, r *http.Request, p params, version string) error {
+	req, err := h.parseMapRequest(p, version)
+	if err != nil {
+		return err
+	}
+
+	xParam, yParam := "x", "y"
+	if version == "1.3.0" {
+		xParam, yParam = "i", "j"
+	}
+	x, err := strconv.Atoi(p[xParam])
+	if err != nil || x < 0 || x >= req.width {
+		return invalidParam("InvalidPoint", "invalid %s: %q", strings.ToUpper(xParam), p[xParam])
+	}
+	y, err := strconv.Atoi(p[yParam])
+	if err != nil || y < 0 || y >= req.height {
+		return invalidParam("InvalidPoint", "invalid %s: %q", strings.ToUpper(yParam), p[yParam])
+	}
+
+	if p["query_layers"] == "" {
+		return invalidParam("MissingParameterValue", "missing QUERY_LAYERS")
+	}
+	queryLayers := strings.Split(p["query_layers"], ",")
+	for _, l := range queryLayers {
+		if !h.hasLayer(l) {
+			return invalidParam("LayerNotDefined", "unknown layer: %q", l)
+		}
+	}
+
+	featureCount := 1
+	if fc := p["feature_count"]; fc != "" {
+		if featureCount, err = strconv.Atoi(fc); err != nil || featureCount <= 0 {
+			return invalidParam("InvalidParameterValue", "invalid FEATURE_COUNT: %q", fc)
+		}
+	}
+
+	infoFormat := p["info_format"]
+	if infoFormat == "" {
+		infoFormat = "text/plain"
+	}
+	if infoFormat != "text/plain" && infoFormat != "application/json" {
+		return invalidParam("InvalidFormat", "unsupported INFO_FORMAT: %q", infoFormat)
+	}
+
+	var result []layerFeatures
+	err = h.withMap(r, req, func(m *mapnik.Map) error {
+		for _, l := range queryLayers {
+			features, err := m.QueryPoint(l, float64(x), float64(y))
+			if err != nil {
+				return err
+			}
+			if len(features) > featureCount {
+				features = features[:featureCount]
+			}
+			lf := layerFeatures{Name: l, Features: []featureResult{}}
+			for _, f := range features {
+				lf.Features = append(lf.Features, featureResult{ID: f.ID, Properties: f.Attributes})
+			}
+			result = append(result, lf)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", infoFormat)
+	if infoFormat == "application/json" {
+		return json.NewEncoder(w).Encode(map[string]interface{}{"layers": result})
+	}
+	for _, lf := range result {
+		fmt.Fprintf(w, "Layer '%s'\n", lf.Name)
+		for _, f := range lf.Features {
+			fmt.Fprintf(w, "  Feature %d\n", f.ID)
+			keys := make([]string, 0, len(f.Properties))
+			for k := range f.Properties {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				fmt.Fprintf(w, "    %s = '%s'\n", k, f.Properties[k])
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,247 @@
+package wms
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	_ "image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	h, err := New(Config{Stylesheet: "../test/map.xml", PoolSize: 2, Title: "Test & WMS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func get(h http.Handler, query string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", "http://localhost/service?"+query, nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetCapabilities(t *testing.T) {
+	h := newTestHandler(t)
+
+	for _, tc := range []struct {
+		version     string
+		contentType string
+		contains    []string
+	}{
+		{"1.1.1", "application/vnd.ogc.wms_xml", []string{
+			`<WMT_MS_Capabilities version="1.1.1"`,
+			`<Title>Test &amp; WMS</Title>`,
+			`<SRS>EPSG:3857</SRS>`,
+			`<Name>layerA</Name>`,
+			`<Name>layerD</Name>`,
+			`<LatLonBoundingBox minx="4" miny="49" maxx="12" maxy="54"/>`,
+			`<BoundingBox SRS="EPSG:4326" minx="4" miny="49" maxx="12" maxy="54"/>`,
+			`xlink:href="http://localhost/service?"`,
+		}},
+		{"1.3.0", "text/xml", []string{
+			`<WMS_Capabilities version="1.3.0"`,
+			`<CRS>CRS:84</CRS>`,
+			`<Name>layerB</Name>`,
+			`<westBoundLongitude>4</westBoundLongitude>`,
+			`<BoundingBox CRS="EPSG:4326" minx="49" miny="4" maxx="54" maxy="12"/>`,
+		}},
+	} {
+		t.Run(tc.version, func(t *testing.T) {
+			rec := get(h, "SERVICE=WMS&REQUEST=GetCapabilities&VERSION="+tc.version)
+			if rec.Code != 200 {
+				t.Fatal("unexpected status", rec.Code, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != tc.contentType {
+				t.Error("unexpected content type", ct)
+			}
+			body := rec.Body.String()
+			for _, c := range tc.contains {
+				if !strings.Contains(body, c) {
+					t.Errorf("%q not found in capabilities:\n%s", c, body)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMap(t *testing.T) {
+	h := newTestHandler(t)
+
+	for _, query := range []string{
+		"SERVICE=WMS&REQUEST=GetMap&VERSION=1.1.1&SRS=EPSG:4326&BBOX=0,40,20,60&WIDTH=200&HEIGHT=100&LAYERS=layerA,layerB&FORMAT=image/png",
+		"service=wms&request=getmap&version=1.3.0&crs=EPSG:4326&bbox=40,0,60,20&width=200&height=100&layers=layerA&format=image/png&transparent=true",
+		"SERVICE=WMS&REQUEST=GetMap&VERSION=1.3.0&CRS=EPSG:3857&BBOX=0,5000000,2000000,8000000&WIDTH=200&HEIGHT=100&LAYERS=layerD&FORMAT=image/png; mode=8bit",
+	} {
+		rec := get(h, query)
+		if rec.Code != 200 {
+			t.Fatal("unexpected status", rec.Code, rec.Body.String())
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+			t.Error("unexpected content type", ct)
+		}
+		img, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds() != image.Rect(0, 0, 200, 100) {
+			t.Error("unexpected image size", img.Bounds())
+		}
+	}
+}
+
+func TestGetMapTransparent(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := get(h, "REQUEST=GetMap&VERSION=1.3.0&CRS=CRS:84&BBOX=-20,-10,0,0&WIDTH=20&HEIGHT=10&LAYERS=layerA&FORMAT=image/png&TRANSPARENT=TRUE")
+	img, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Error("background not transparent")
+	}
+
+	// opaque after a transparent request
+	rec = get(h, "REQUEST=GetMap&VERSION=1.3.0&CRS=CRS:84&BBOX=-20,-10,0,0&WIDTH=20&HEIGHT=10&LAYERS=layerA&FORMAT=image/png")
+	img, _, err = image.Decode(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err, rec.Body.String())
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0xffff {
+		t.Error("background transparent")
+	}
+}
+
+func TestGetMapDefaultBackground(t *testing.T) {
+	geojson, err := filepath.Abs("../test/map.geojson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stylesheet without background-color
+	fname := filepath.Join(t.TempDir(), "style.xml")
+	if err := os.WriteFile(fname, []byte(`<Map srs="epsg:4326">
+    <Style name="polygon">
+        <Rule>
+            <PolygonSymbolizer />
+        </Rule>
+    </Style>
+    <Layer name="layerA" srs="epsg:4326">
+        <StyleName>polygon</StyleName>
+        <Datasource>
+            <Parameter name="file">`+geojson+`</Parameter>
+            <Parameter name="type">geojson</Parameter>
+        </Datasource>
+    </Layer>
+</Map>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h, err := New(Config{Stylesheet: fname, PoolSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for _, tc := range []struct {
+		query string
+		color color.NRGBA
+	}{
+		{"", color.NRGBA{255, 255, 255, 255}},
+		{"&TRANSPARENT=FALSE", color.NRGBA{255, 255, 255, 255}},
+		{"&BGCOLOR=0xFF0000", color.NRGBA{255, 0, 0, 255}},
+		{"&TRANSPARENT=TRUE", color.NRGBA{}},
+	} {
+		rec := get(h, "REQUEST=GetMap&VERSION=1.3.0&CRS=CRS:84&BBOX=-20,-10,0,0&WIDTH=20&HEIGHT=10&LAYERS=layerA&FORMAT=image/png"+tc.query)
+		img, _, err := image.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			t.Fatal(err, rec.Body.String())
+		}
+		if c := color.NRGBAModel.Convert(img.At(0, 0)); c != tc.color {
+			t.Errorf("unexpected background for %q: %v", tc.query, c)
+		}
+	}
+}
+
+func TestGetMapErrors(t *testing.T) {
+	h := newTestHandler(t)
+
+	for _, tc := range []struct {
+		query string
+		code  string
+	}{
+		{"REQUEST=GetMap&VERSION=1.1.1&SRS=EPSG:31467&BBOX=0,40,20,60&WIDTH=200&HEIGHT=100&LAYERS=layerA&FORMAT=image/png", "InvalidSRS"},
+		{"REQUEST=GetMap&VERSION=1.3.0&CRS=EPSG:31467&BBOX=0,40,20,60&WIDTH=200&HEIGHT=100&LAYERS=layerA&FORMAT=image/png", "InvalidCRS"},
+		{"REQUEST=GetMap&VERSION=1.3.0&CRS=EPSG:4326&BBOX=0,40,20,60&WIDTH=200&HEIGHT=100&LAYERS=unknown&FORMAT=image/png", "LayerNotDefined"},
+		{"REQUEST=GetMap&VERSION=1.3.0&CRS=EPSG:4326&BBOX=0,40,20,60&WIDTH=200&HEIGHT=100&LAYERS=layerA&FORMAT=image/gif", "InvalidFormat"},
+		{"REQUEST=GetMap&VERSION=1.3.0&CRS=EPSG:4326&BBOX=0,40,20&WIDTH=200&HEIGHT=100&LAYERS=layerA&FORMAT=image/png", "MissingParameterValue"},
+		{"REQUEST=GetMap&VERSION=1.3.0&CRS=EPSG:4326&BBOX=0,40,20,60&WIDTH=20000&HEIGHT=100&LAYERS=layerA&FORMAT=image/png", "InvalidParameterValue"},
+		{"REQUEST=GetLegendGraphic", "OperationNotSupported"},
+	} {
+		rec := get(h, tc.query)
+		if rec.Code != 400 {
+			t.Error("unexpected status", rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), `code="`+tc.code+`"`) {
+			t.Errorf("expected %s exception for %s:\n%s", tc.code, tc.query, rec.Body.String())
+		}
+	}
+}
+
+func TestGetFeatureInfo(t *testing.T) {
+	h := newTestHandler(t)
+
+	rec := get(h, "REQUEST=GetFeatureInfo&VERSION=1.3.0&CRS=EPSG:4326&BBOX=40,0,60,20&WIDTH=200&HEIGHT=200"+
+		"&LAYERS=layerA&QUERY_LAYERS=layerA,layerB&I=80&J=80&INFO_FORMAT=application/json")
+	if rec.Code != 200 {
+		t.Fatal("unexpected status", rec.Code, rec.Body.String())
+	}
+	var result struct {
+		Layers []layerFeatures `json:"layers"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Layers) != 2 || result.Layers[0].Name != "layerA" || len(result.Layers[0].Features) != 1 {
+		t.Error("unexpected result", result)
+	}
+
+	// outside of the polygon
+	rec = get(h, "REQUEST=GetFeatureInfo&VERSION=1.1.1&SRS=EPSG:4326&BBOX=0,40,20,60&WIDTH=200&HEIGHT=200"+
+		"&LAYERS=layerA&QUERY_LAYERS=layerA&X=10&Y=10")
+	if rec.Code != 200 {
+		t.Fatal("unexpected status", rec.Code, rec.Body.String())
+	}
+	if body := rec.Body.String(); body != "Layer 'layerA'\n" {
+		t.Errorf("unexpected result %q", body)
+	}
+
+	rec = get(h, "REQUEST=GetFeatureInfo&VERSION=1.3.0&CRS=EPSG:4326&BBOX=40,0,60,20&WIDTH=200&HEIGHT=200"+
+		"&LAYERS=layerA&QUERY_LAYERS=layerA&I=300&J=80")
+	if !strings.Contains(rec.Body.String(), `code="InvalidPoint"`) {
+		t.Error("expected InvalidPoint exception", rec.Body.String())
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	c, err := parseColor("0xFF8000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.R != 255 || c.G != 128 || c.B != 0 || c.A != 255 {
+		t.Error("unexpected color", c)
+	}
+	for _, s := range []string{"", "0xFFF", "0xGGGGGG"} {
+		if _, err := parseColor(s); err == nil {
+			t.Error("expected error for", s)
+		}
+	}
+}